@@ -122,3 +122,19 @@ func TestUser(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "1000", user)
 }
+
+func TestSquash(t *testing.T) {
+	RegisterTestingT(t)
+
+	target := getRegistry() + "/publish/squashed"
+	Expect(spectrum("build", "-b", "adoptopenjdk/openjdk8:slim",
+		"-t", target,
+		"--push-insecure="+getRegistryInsecure(),
+		"--squash",
+		"./files/01-simple:/app", "./files/02-override:/app")).To(BeNil())
+
+	layers, err := getImageLayers(target, isRegistryInsecure())
+	assert.Nil(t, err)
+	assert.Len(t, layers, 1)
+	assertDataMatch(t, target, isRegistryInsecure(), "/app", "./files/03-merge", false)
+}
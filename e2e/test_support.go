@@ -61,6 +61,19 @@ func getImageUser(image string, insecure bool) (string, error) {
 	return configFile.Config.User, err
 }
 
+func getImageLayers(image string, insecure bool) ([]v1.Layer, error) {
+	options := []crane.Option(nil)
+	if insecure {
+		options = append(options, crane.Insecure)
+	}
+
+	img, err := crane.Pull(image, options...)
+	if err != nil {
+		return nil, err
+	}
+	return img.Layers()
+}
+
 func getImageConfigFile(image string, insecure bool) (v1.ConfigFile, error) {
 	options := []crane.Option(nil)
 	if insecure {
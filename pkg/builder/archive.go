@@ -0,0 +1,257 @@
+package builder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ArchiveSourcePrefix identifies mappings whose local path is an archive (tar, tar.gz or zip) to be extracted
+// under the target path, e.g. archive://./dist.tar.gz:/deployments
+const ArchiveSourcePrefix = "archive://"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+)
+
+// archivePackage extracts the content of the archive under the target path into a layer tar file, preserving
+// modes and symbolic links. Entries escaping the target path are rejected.
+func archivePackage(name, targetPath string) (file string, err error) {
+	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	writer := tar.NewWriter(layerFile)
+	defer writer.Close()
+
+	archive, err := openArchive(name)
+	if err != nil {
+		return layerFile.Name(), err
+	}
+	defer archive.Close()
+
+	return layerFile.Name(), archive.extract(newArchiveExtractor(targetPath, writer))
+}
+
+// streamPackage extracts a tar stream, optionally compressed with gzip, under the target path into a layer tar file
+func streamPackage(reader io.Reader, targetPath string) (file string, err error) {
+	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	writer := tar.NewWriter(layerFile)
+	defer writer.Close()
+
+	archive, err := newTarArchive(reader, nil)
+	if err != nil {
+		return layerFile.Name(), err
+	}
+	defer archive.Close()
+
+	return layerFile.Name(), archive.extract(newArchiveExtractor(targetPath, writer))
+}
+
+type archive interface {
+	extract(extractor *archiveExtractor) error
+	Close() error
+}
+
+func openArchive(name string) (archive, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(file, magic); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+
+	if bytes.HasPrefix(magic, zipMagic) {
+		file.Close()
+		zipReader, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, err
+		}
+		return zipArchive{zipReader}, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	archive, err := newTarArchive(file, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+func newTarArchive(r io.Reader, closer io.Closer) (archive, error) {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(magic, gzipMagic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return tarArchive{Reader: tar.NewReader(gzipReader), closer: closer}, nil
+	}
+	return tarArchive{Reader: tar.NewReader(reader), closer: closer}, nil
+}
+
+type tarArchive struct {
+	*tar.Reader
+	closer io.Closer
+}
+
+func (a tarArchive) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+func (a tarArchive) extract(extractor *archiveExtractor) error {
+	for {
+		header, err := a.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := extractor.add(header, a); err != nil {
+			return err
+		}
+	}
+}
+
+type zipArchive struct {
+	*zip.ReadCloser
+}
+
+func (a zipArchive) extract(extractor *archiveExtractor) error {
+	for _, f := range a.File {
+		if err := a.extractFile(extractor, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a zipArchive) extractFile(extractor *archiveExtractor, f *zip.File) error {
+	content, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	info := f.FileInfo()
+	header := &tar.Header{
+		Name:     f.Name,
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  f.Modified,
+		Typeflag: tar.TypeReg,
+		Size:     int64(f.UncompressedSize64),
+	}
+	switch {
+	case info.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Size = 0
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := ioutil.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(target)
+		header.Size = 0
+	}
+	return extractor.add(header, content)
+}
+
+type archiveExtractor struct {
+	targetPath string
+	writer     *tar.Writer
+	symlinks   map[string]bool
+}
+
+func newArchiveExtractor(targetPath string, writer *tar.Writer) *archiveExtractor {
+	return &archiveExtractor{
+		targetPath: targetPath,
+		writer:     writer,
+		symlinks:   make(map[string]bool),
+	}
+}
+
+// add writes the archive entry into the layer, relocated under the target path
+func (e *archiveExtractor) add(header *tar.Header, content io.Reader) error {
+	name, err := e.entryPath(header.Name)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		e.symlinks[name] = true
+	case tar.TypeLink:
+		linkname, err := e.entryPath(header.Linkname)
+		if err != nil {
+			return err
+		}
+		header.Linkname = path.Join(e.targetPath, linkname)
+	case tar.TypeReg, tar.TypeDir:
+	default:
+		return fmt.Errorf("unsupported archive entry %s (type %q)", header.Name, header.Typeflag)
+	}
+
+	header.Name = path.Join(e.targetPath, name)
+	if header.Typeflag == tar.TypeDir {
+		header.Name = header.Name + "/"
+	}
+	header.Format = tar.FormatPAX
+	if err := e.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeReg {
+		if _, err := io.Copy(e.writer, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryPath validates the name of an archive entry and returns it relative to the archive root. Entries that would
+// be written outside of the target path, either directly or through a symbolic link, are rejected.
+func (e *archiveExtractor) entryPath(name string) (string, error) {
+	entry := path.Clean(strings.TrimLeft(name, "/"))
+	if entry == ".." || strings.HasPrefix(entry, "../") {
+		return "", errors.New("illegal archive entry " + name + ": path is outside of the target directory")
+	}
+	for dir := path.Dir(entry); dir != "."; dir = path.Dir(dir) {
+		if e.symlinks[dir] {
+			return "", errors.New("illegal archive entry " + name + ": path is inside the symbolic link " + dir)
+		}
+	}
+	return entry, nil
+}
@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestArchive(t *testing.T, compress bool, headers ...*tar.Header) string {
+	archiveFile, err := os.CreateTemp("", "spectrum-test-*.tar.gz")
+	assert.NoError(t, err)
+	defer archiveFile.Close()
+	t.Cleanup(func() { os.Remove(archiveFile.Name()) })
+
+	var w io.Writer = archiveFile
+	if compress {
+		gz := gzip.NewWriter(archiveFile)
+		defer gz.Close()
+		w = gz
+	}
+	writer := tar.NewWriter(w)
+	defer writer.Close()
+	for _, header := range headers {
+		assert.NoError(t, writer.WriteHeader(header))
+		if header.Size > 0 {
+			_, err := writer.Write(make([]byte, header.Size))
+			assert.NoError(t, err)
+		}
+	}
+	return archiveFile.Name()
+}
+
+func readTestHeaders(t *testing.T, tarFile string) map[string]*tar.Header {
+	headers, err := readHeaders(tarFile)
+	assert.NoError(t, err)
+	return headers
+}
+
+func TestArchivePackageTarGz(t *testing.T) {
+	archive := writeTestArchive(t, true,
+		&tar.Header{Name: "./bin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		&tar.Header{Name: "./bin/run.sh", Typeflag: tar.TypeReg, Mode: 0o750, Size: 4},
+		&tar.Header{Name: "./run", Typeflag: tar.TypeSymlink, Linkname: "bin/run.sh", Mode: 0o777},
+		&tar.Header{Name: "./run-link", Typeflag: tar.TypeLink, Linkname: "./bin/run.sh"},
+	)
+
+	tarFile, err := archivePackage(archive, "/deployments")
+	defer os.Remove(tarFile)
+	assert.NoError(t, err)
+
+	headers := readTestHeaders(t, tarFile)
+	assert.Len(t, headers, 4)
+	assert.Equal(t, byte(tar.TypeDir), headers["/deployments/bin"].Typeflag)
+	assert.Equal(t, int64(0o750), headers["/deployments/bin/run.sh"].Mode)
+	assert.Equal(t, int64(4), headers["/deployments/bin/run.sh"].Size)
+	assert.Equal(t, byte(tar.TypeSymlink), headers["/deployments/run"].Typeflag)
+	assert.Equal(t, "bin/run.sh", headers["/deployments/run"].Linkname)
+	assert.Equal(t, "/deployments/bin/run.sh", headers["/deployments/run-link"].Linkname)
+}
+
+func TestArchivePackageZip(t *testing.T) {
+	archiveFile, err := os.CreateTemp("", "spectrum-test-*.zip")
+	assert.NoError(t, err)
+	defer os.Remove(archiveFile.Name())
+	writer := zip.NewWriter(archiveFile)
+	header := &zip.FileHeader{Name: "lib/app.jar"}
+	header.SetMode(0o640)
+	w, err := writer.CreateHeader(header)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("jar"))
+	assert.NoError(t, err)
+	header = &zip.FileHeader{Name: "app.jar"}
+	header.SetMode(os.ModeSymlink | 0o777)
+	w, err = writer.CreateHeader(header)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("lib/app.jar"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, archiveFile.Close())
+
+	tarFile, err := archivePackage(archiveFile.Name(), "/deployments")
+	defer os.Remove(tarFile)
+	assert.NoError(t, err)
+
+	headers := readTestHeaders(t, tarFile)
+	assert.Len(t, headers, 2)
+	assert.Equal(t, int64(0o640), headers["/deployments/lib/app.jar"].Mode)
+	assert.Equal(t, int64(3), headers["/deployments/lib/app.jar"].Size)
+	assert.Equal(t, byte(tar.TypeSymlink), headers["/deployments/app.jar"].Typeflag)
+	assert.Equal(t, "lib/app.jar", headers["/deployments/app.jar"].Linkname)
+}
+
+func TestArchivePackageTraversal(t *testing.T) {
+	tests := map[string][]*tar.Header{
+		"parent": {
+			&tar.Header{Name: "../../etc/passwd", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1},
+		},
+		"nested parent": {
+			&tar.Header{Name: "lib/../../passwd", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1},
+		},
+		"hard link": {
+			&tar.Header{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../etc/passwd"},
+		},
+		"symbolic link": {
+			&tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc", Mode: 0o777},
+			&tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1},
+		},
+	}
+
+	for name, headers := range tests {
+		tarFile, err := archivePackage(writeTestArchive(t, false, headers...), "/deployments")
+		os.Remove(tarFile)
+		assert.Error(t, err, name)
+	}
+}
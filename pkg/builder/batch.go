@@ -0,0 +1,252 @@
+package builder
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// BatchResult is the outcome of one of the builds of a batch
+type BatchResult struct {
+	Target string
+	// Result is the result of the build, nil if it failed
+	Result *BuildResult
+	// Err is the error that made the build fail, if any
+	Err error
+}
+
+// BuildBatch runs the builds concurrently, at most parallelism at a time (all of them if zero or less), and returns
+// their results in the same order. A failed build doesn't stop the others. The base images shared by the builds are
+// pulled only once, and their layers downloaded at most once, e.g. when squashed, into a temporary directory removed
+// once the batch is done.
+func BuildBatch(ctx context.Context, parallelism int, builds ...Options) []BatchResult {
+	if parallelism <= 0 || parallelism > len(builds) {
+		parallelism = len(builds)
+	}
+	bases := &sharedBases{ctx: ctx, images: make(map[string]*sharedBase), layers: make(map[v1.Hash]*sharedLayer)}
+	defer bases.close()
+	results := make([]BatchResult, len(builds))
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, options := range builds {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, options Options) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			options.bases = bases
+			result, err := BuildContext(ctx, options)
+			results[i] = BatchResult{Target: options.Target, Result: result, Err: err}
+		}(i, options)
+	}
+	wg.Wait()
+	return results
+}
+
+// sharedBases pulls each base image once for all the builds of a batch, and keeps the layers downloaded by any of
+// them in a temporary directory
+type sharedBases struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	images map[string]*sharedBase
+	layers map[v1.Hash]*sharedLayer
+	dir    string
+}
+
+// sharedLayer is a base layer downloaded once for all the builds, into file
+type sharedLayer struct {
+	mu   sync.Mutex
+	file string
+}
+
+type sharedBase struct {
+	once  sync.Once
+	image v1.Image
+	err   error
+}
+
+// pull returns the base image of the build, pulling it if no other build did with the same pull options. The
+// progress of the layers is reported to the events of each build.
+func (s *sharedBases) pull(options Options) (v1.Image, error) {
+	key := strings.Join([]string{options.Base, strconv.FormatBool(options.PullInsecure), options.PullConfigDir}, "\x00")
+	s.mu.Lock()
+	base, ok := s.images[key]
+	if !ok {
+		base = &sharedBase{}
+		s.images[key] = base
+	}
+	s.mu.Unlock()
+
+	base.once.Do(func() {
+		// The image fetches the layers lazily, so it must not be bound to the context or the events of the
+		// build that happens to pull it
+		pullOptions := options
+		pullOptions.ctx = s.ctx
+		pullOptions.Events = nil
+		base.image, base.err = Pull(pullOptions)
+		if base.err == nil {
+			base.image = &sharedImage{Image: base.image, bases: s}
+		}
+	})
+	if base.err != nil || options.Events == nil {
+		return base.image, base.err
+	}
+	return withProgress(base.image, PullOperation, options.Events, nil), nil
+}
+
+// pullBase pulls the base image of the build, sharing it with the other builds of the batch if any
+func pullBase(options Options) (v1.Image, error) {
+	if options.bases == nil {
+		return Pull(options)
+	}
+	return options.bases.pull(options)
+}
+
+// close removes the downloaded layers
+func (s *sharedBases) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// layer returns the shared download of the layer with the given digest
+func (s *sharedBases) layer(digest v1.Hash) *sharedLayer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	layer, ok := s.layers[digest]
+	if !ok {
+		layer = &sharedLayer{}
+		s.layers[digest] = layer
+	}
+	return layer
+}
+
+// download writes the compressed layer into the temporary directory, unless already done by another build. A failed
+// download is retried by the next build reading the layer.
+func (s *sharedBases) download(layer v1.Layer) (string, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return "", err
+	}
+	shared := s.layer(digest)
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	if shared.file != "" {
+		return shared.file, nil
+	}
+
+	s.mu.Lock()
+	if s.dir == "" {
+		s.dir, err = os.MkdirTemp("", "spectrum-batch-*")
+	}
+	dir := s.dir
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, digest.Algorithm+"-"+digest.Hex)
+	if err := writeLayer(layer, file); err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	shared.file = file
+	return file, nil
+}
+
+// writeLayer writes the compressed content of the layer into the file, that is verified against the digest of the
+// layer as it's read
+func writeLayer(layer v1.Layer, file string) error {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// sharedImage reads the layers of the base image from the downloads shared by the builds of the batch
+type sharedImage struct {
+	v1.Image
+	bases *sharedBases
+}
+
+func (i *sharedImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]v1.Layer, 0, len(layers))
+	for _, layer := range layers {
+		wrapped = append(wrapped, i.wrap(layer))
+	}
+	return wrapped, nil
+}
+
+func (i *sharedImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return i.wrap(layer), nil
+}
+
+func (i *sharedImage) LayerByDiffID(h v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDiffID(h)
+	if err != nil {
+		return nil, err
+	}
+	return i.wrap(layer), nil
+}
+
+// wrap downloads the layer only when its content is read, and keeps mountable layers mountable, so that pushing
+// them across repositories of the same registry still doesn't download them
+func (i *sharedImage) wrap(layer v1.Layer) v1.Layer {
+	mountable, isMountable := layer.(*remote.MountableLayer)
+	if isMountable {
+		layer = mountable.Layer
+	}
+	wrapped, _ := partial.CompressedToLayer(&sharedImageLayer{Layer: layer, bases: i.bases})
+	if isMountable {
+		return &remote.MountableLayer{Layer: wrapped, Reference: mountable.Reference}
+	}
+	return wrapped
+}
+
+type sharedImageLayer struct {
+	v1.Layer
+	bases *sharedBases
+}
+
+func (l *sharedImageLayer) Descriptor() (*v1.Descriptor, error) {
+	return partial.Descriptor(l.Layer)
+}
+
+func (l *sharedImageLayer) Compressed() (io.ReadCloser, error) {
+	file, err := l.bases.download(l.Layer)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(file)
+}
@@ -0,0 +1,116 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildBatch(t *testing.T) {
+	const parallelism = 2
+	var baseManifestRequests, baseLayerRequests int32
+	var baseLayer string
+
+	// A build is running from its first request for its target until its manifest is pushed
+	var mu sync.Mutex
+	running := make(map[string]bool)
+	done := make(map[string]bool)
+	maxRunning := 0
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/base/manifests/") {
+			atomic.AddInt32(&baseManifestRequests, 1)
+		}
+		if r.Method == http.MethodGet && baseLayer != "" && r.URL.Path == "/v2/base/blobs/"+baseLayer {
+			atomic.AddInt32(&baseLayerRequests, 1)
+		}
+		target, isTarget := strings.CutPrefix(r.URL.Path, "/v2/app-")
+		target, _, _ = strings.Cut(target, "/")
+		if isTarget {
+			mu.Lock()
+			if !done[target] {
+				running[target] = true
+				if len(running) > maxRunning {
+					maxRunning = len(running)
+				}
+			}
+			mu.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+		if isTarget && r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			mu.Lock()
+			delete(running, target)
+			done[target] = true
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	base := host + "/base:1.0"
+	ref, err := name.ParseReference(base)
+	assert.NoError(t, err)
+	img := testImage(t, writeTestTar(t, testEntry{name: "/opt/base.txt", content: "base"}))
+	assert.NoError(t, remote.Write(ref, img))
+	layers, err := img.Layers()
+	assert.NoError(t, err)
+	digest, err := layers[0].Digest()
+	assert.NoError(t, err)
+	baseLayer = digest.String()
+	baseManifestRequests = 0
+
+	tmpDir, err := os.MkdirTemp("", "spectrum-batch-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	var builds []Options
+	for i := 0; i < 5; i++ {
+		source := tmpDir
+		if i == 2 {
+			source = filepath.Join(tmpDir, "missing")
+		}
+		builds = append(builds, Options{
+			Base:         base,
+			Target:       fmt.Sprintf("%s/app-%d:1.0", host, i),
+			PullInsecure: true,
+			PushInsecure: true,
+			Mappings:     []Mapping{{Source: source, Destination: "/deployments/"}},
+			// Squashing reads the layers of the base image, that are otherwise mounted
+			Squash: i >= 3,
+		})
+	}
+
+	results := BuildBatch(context.Background(), parallelism, builds...)
+	assert.Len(t, results, len(builds))
+	for i, result := range results {
+		assert.Equal(t, builds[i].Target, result.Target)
+		if i == 2 {
+			assert.Error(t, result.Err)
+			assert.Nil(t, result.Result)
+			continue
+		}
+		assert.NoError(t, result.Err)
+		ref, err := name.ParseReference(result.Target)
+		assert.NoError(t, err)
+		descriptor, err := remote.Head(ref)
+		assert.NoError(t, err)
+		assert.Equal(t, result.Result.Digest, descriptor.Digest.String())
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&baseManifestRequests))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&baseLayerRequests))
+	assert.LessOrEqual(t, maxRunning, parallelism)
+	assert.Len(t, done, len(builds)-1)
+}
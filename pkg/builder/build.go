@@ -2,124 +2,347 @@ package builder
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"time"
 
-	"github.com/google/go-containerregistry/pkg/logs"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// LogPrefix used to prefix the lines logged by the builds.
+//
+// Deprecated: the builds log structured records through log/slog, see Options.Logger. LogPrefix isn't used
+// anymore, and it will be removed in the next release.
 const LogPrefix = "spectrum - "
 
-var StepLogger = log.New(ioutil.Discard, LogPrefix, log.LstdFlags)
+// StepLogger used to receive the build steps.
+//
+// Deprecated: the steps are logged to the logger of the options of each build, see Options.Logger. Nothing is
+// logged to StepLogger anymore, and it will be removed in the next release.
+var StepLogger = log.New(io.Discard, LogPrefix, log.LstdFlags)
 
 // Build executes the full build cycle and returns the image digest
 func Build(options Options, dirs ...string) (string, error) {
-	configureLogging(options)
-	StepLogger.Printf("Pulling base image %s (insecure=%v)...", options.Base, options.PullInsecure)
-	base, err := Pull(options)
+	result, err := BuildContext(context.Background(), options, dirs...)
 	if err != nil {
-		return "", errors.Wrapf(err, "could not pull base image image %s", options.Base)
+		return "", err
 	}
+	return result.Digest, nil
+}
 
-	StepLogger.Println("Composing layers...")
-	tarFiles := make([]string, 0)
+// BuildContext executes the full build cycle within the given context and returns its result. The build is
+// traced as a child of the span of the context, if any, and it's canceled when the context is done.
+func BuildContext(ctx context.Context, options Options, dirs ...string) (*BuildResult, error) {
+	start := time.Now()
+	result := &BuildResult{Base: options.Base, Target: options.Target}
+	options.ctx = ctx
+	options.result = result
+	options, span := startSpan(options, "build", attribute.String("base", options.Base), attribute.String("target", options.Target))
+
+	digest, err := build(options.withLoggers(), dirs...)
+	result.Digest = digest
+	result.Duration = time.Since(start)
+	endSpan(span, err)
+	if events, ok := options.Events.(BuildCompleteEvents); ok {
+		events.OnBuildComplete(result, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func build(options Options, dirs ...string) (string, error) {
+	logger := options.logger()
+	sink, ref := resolveSink(options.Target, options)
+	logger.Info("Pulling base image", "phase", "pull", "image", options.Base, "insecure", options.PullInsecure)
+	options.events().OnPullStart(options.Base)
+	start := time.Now()
+	pullOptions, span := startSpan(options, "pull", attribute.String("image", options.Base))
+	base, err := pullBase(pullOptions)
+	endSpan(span, err)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not pull base image image %s", options.Base)
+	}
+	options.result.PullDuration = time.Since(start)
+	logger.Info("Pulled base image", "phase", "pull", "image", options.Base, "duration", options.result.PullDuration)
+
+	logger.Info("Composing layers", "phase", "package")
+	additions := make([]mutate.Addendum, 0)
+	if len(options.Remove) > 0 {
+		logger.Info("Removing paths from base image", "phase", "package", "paths", options.Remove)
+		start := time.Now()
+		packageOptions, span := startSpan(options, "package", attribute.String("source", "remove"))
+		tarFile, err := whiteoutPackage(options.Remove)
+		if tarFile != "" {
+			defer os.Remove(tarFile)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "cannot package removed paths as tar file")
+			endSpan(span, err)
+			return "", err
+		}
+		addendum, err := compressLayer(packageOptions, tarFile)
+		if err == nil {
+			err = layerPackaged(packageOptions, "remove", addendum, start)
+		}
+		endSpan(span, err)
+		if err != nil {
+			return "", err
+		}
+		additions = append(additions, addendum)
+	}
+	mappings := append([]Mapping(nil), options.Mappings...)
 	for _, spec := range dirs {
-		localPath, targetPath, err := getPaths(spec, runtime.GOOS)
+		mapping, err := ParseMapping(spec)
 		if err != nil {
 			return "", err
 		}
-
-		tarFile, err := tarPackage(localPath, targetPath, options.Recursive)
+		mappings = append(mappings, mapping)
+	}
+	stdinMappings := 0
+	for _, mapping := range mappings {
+		if mapping.Source == StdinSource {
+			stdinMappings++
+		}
+	}
+	if stdinMappings > 1 {
+		return "", errors.New("the standard input can be used by one mapping only")
+	}
+	for _, mapping := range mappings {
+		if err := options.context().Err(); err != nil {
+			return "", err
+		}
+		start := time.Now()
+		packageOptions, span := startSpan(options, "package",
+			attribute.String("source", mapping.Source), attribute.String("destination", mapping.Destination))
+		addendum, tarFile, reused, err := packageCachedMapping(mapping, packageOptions)
+		if tarFile != "" {
+			defer os.Remove(tarFile)
+		}
+		if err == nil && reused {
+			err = layerReused(packageOptions, mapping.Source, addendum)
+		} else if err == nil {
+			err = layerPackaged(packageOptions, mapping.Source, addendum, start)
+		}
+		endSpan(span, err)
+		if err != nil {
+			return "", err
+		}
+		additions = append(additions, addendum)
+	}
+	if len(options.Files) > 0 {
+		logger.Info("Adding files", "phase", "package", "files", len(options.Files))
+		start := time.Now()
+		packageOptions, span := startSpan(options, "package", attribute.String("source", "files"))
+		tarFile, err := filesPackage(options.Files, options.warnings())
+		if tarFile != "" {
+			defer os.Remove(tarFile)
+		}
 		if err != nil {
-			return "", errors.Wrapf(err, "cannot package dir %s as tar file", localPath)
+			err = errors.Wrap(err, "cannot package files as tar file")
+			endSpan(span, err)
+			return "", err
+		}
+		addendum, err := compressLayer(packageOptions, tarFile)
+		if err == nil {
+			err = layerPackaged(packageOptions, "files", addendum, start)
+		}
+		endSpan(span, err)
+		if err != nil {
+			return "", err
+		}
+		additions = append(additions, addendum)
+	}
+	if options.SquashAdded && len(additions) > 1 {
+		logger.Info("Squashing added layers", "phase", "package", "layers", len(additions))
+		start := time.Now()
+		squashOptions, span := startSpan(options, "squash", attribute.Int("layers", len(additions)))
+		tarFile, err := squashAdditions(additions)
+		if tarFile != "" {
+			defer os.Remove(tarFile)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "could not squash added layers")
+			endSpan(span, err)
+			return "", err
+		}
+		addendum, err := compressLayer(squashOptions, tarFile)
+		if err == nil {
+			err = layerPackaged(squashOptions, "squash-added", addendum, start)
+		}
+		endSpan(span, err)
+		if err != nil {
+			return "", err
 		}
-		defer os.Remove(tarFile)
-		tarFiles = append(tarFiles, tarFile)
+		additions = []mutate.Addendum{addendum}
 	}
-	newImage, err := appendPaths(base, options.Annotations, tarFiles...)
+	newImage, err := appendLayers(base, options.Annotations, additions...)
 	if err != nil {
 		return "", errors.Wrap(err, "could not append tar layers to base image")
 	}
-	confFile, err := newImage.ConfigFile()
+	if options.Squash {
+		logger.Info("Squashing image layers", "phase", "package")
+		var tarFile string
+		_, span := startSpan(options, "squash")
+		newImage, tarFile, err = squashImage(newImage, options.Annotations)
+		endSpan(span, err)
+		if tarFile != "" {
+			defer os.Remove(tarFile)
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "could not squash image layers")
+		}
+	}
+	configOptions, span := startSpan(options, "config")
+	newImage, err = configure(newImage, configOptions)
+	endSpan(span, err)
 	if err != nil {
-		panic(err)
+		return "", errors.Wrap(err, "could not configure image")
 	}
 
-	if options.ClearEntrypoint == true {
-		StepLogger.Println("Clearing entrypoint...")
-		// Change the entry point
-		confFile.Config.Entrypoint = nil
-		newImage, err = mutate.Config(newImage, confFile.Config)
+	if options.Report {
+		reportOptions, span := startSpan(options, "report")
+		options.result.Report, err = report(reportOptions, base, newImage)
+		endSpan(span, err)
 		if err != nil {
-			panic(err)
+			return "", errors.Wrap(err, "could not report image changes")
 		}
 	}
 
-	if options.RunAs != "" {
-		StepLogger.Printf("Setting user as %s", options.RunAs)
-		// Change the configured USER
-		confFile.Config.User = options.RunAs
-		newImage, err = mutate.Config(newImage, confFile.Config)
+	if options.DryRun {
+		planOptions, span := startSpan(options, "plan", attribute.String("target", options.Target))
+		options.result.Plan, err = plan(planOptions, sink, ref, base, newImage)
+		endSpan(span, err)
 		if err != nil {
-			panic(err)
+			return "", errors.Wrap(err, "could not plan image")
 		}
+		hash, err := newImage.Digest()
+		if err != nil {
+			return "", err
+		}
+		logger.Info("Planned image, nothing written", "phase", "plan", "image", options.Target, "digest", hash.String())
+		return hash.String(), nil
 	}
 
-	StepLogger.Printf("Pushing image %s (insecure=%v)...", options.Target, options.PushInsecure)
-
-	if err := Push(newImage, options); err != nil {
+	if _, ok := sink.(RegistrySink); ok {
+		logger.Info("Pushing image", "phase", "push", "image", options.Target, "insecure", options.PushInsecure)
+	} else {
+		logger.Info("Writing image", "phase", "push", "image", options.Target)
+	}
+	start = time.Now()
+	pushOptions, span := startSpan(options, "push", attribute.String("target", options.Target))
+	err = sink.Write(ref, newImage, pushOptions)
+	endSpan(span, err)
+	if err != nil {
 		return "", err
 	}
 	var hash v1.Hash
 	if hash, err = newImage.Digest(); err != nil {
 		return "", err
 	}
+	options.result.PushDuration = time.Since(start)
+	logger.Info("Pushed image", "phase", "push", "image", options.Target, "digest", hash.String(), "duration", options.result.PushDuration)
+	options.events().OnPushComplete(options.Target, hash)
 	return hash.String(), nil
 }
 
+// copyPackage packages the local path into a layer tar file, following the Dockerfile COPY rules: the local path
+// can be a glob pattern and the target path is considered a file, unless it ends with a slash (or is ".") or the
+// local path is a directory. Warnings about the copied files are logged to the given logger, if set.
+func copyPackage(localPath, targetPath string, recursive bool, warnings *slog.Logger) (file string, err error) {
+	names := []string{localPath}
+	if strings.ContainsAny(localPath, "*?[") {
+		names, err = filepath.Glob(localPath)
+		if err != nil {
+			return "", err
+		}
+		if len(names) == 0 {
+			return "", errors.New("no files matching " + localPath)
+		}
+	}
+
+	sources := make([]Source, 0, len(names))
+	for _, name := range names {
+		source, err := HostSource(name)
+		if err != nil {
+			return "", err
+		}
+		source.warnings = warnings
+		sources = append(sources, source)
+	}
+	return packageSources(sources, targetPath, recursive)
+}
+
+// copyFSPackage packages the path of the file system into a layer tar file, following the same rules as copyPackage
+func copyFSPackage(fsys fs.FS, name, targetPath string, recursive bool) (file string, err error) {
+	if !fs.ValidPath(name) {
+		return "", errors.New("invalid path " + name + " (expected an unrooted, slash separated path)")
+	}
+	names := []string{name}
+	if strings.ContainsAny(name, "*?[") {
+		names, err = fs.Glob(fsys, name)
+		if err != nil {
+			return "", err
+		}
+		if len(names) == 0 {
+			return "", errors.New("no files matching " + name)
+		}
+	}
+
+	sources := make([]Source, 0, len(names))
+	for _, name := range names {
+		sources = append(sources, Source{FS: fsys, Path: name})
+	}
+	return packageSources(sources, targetPath, recursive)
+}
+
+func packageSources(sources []Source, targetPath string, recursive bool) (file string, err error) {
+	targetDir := strings.HasSuffix(targetPath, "/") || path.Base(targetPath) == "."
+	if len(sources) > 1 && !targetDir {
+		return "", errors.New("the target path " + targetPath + " must be a directory ending with / when copying multiple files")
+	}
+	return tarPackageSources(sources, targetPath, targetDir, recursive)
+}
+
 func getPaths(paths string, os string) (localPath string, targetPath string, err error) {
 	parts := strings.Split(paths, ":")
-	if len(parts) != 2 && (len(parts) == 3 && os != "windows") {
-		return "", "", errors.New("wrong dir format for " + paths + " (expected \"local:remote\")")
-	}
-	localPath = parts[0]
-	targetPath = parts[1]
 	if os == "windows" && len(parts) == 3 {
 		localPath = fmt.Sprintf("%s:%s", parts[0], parts[1])
 		targetPath = parts[2]
+		return localPath, targetPath, nil
 	}
-	return localPath, targetPath, nil
-}
-
-func configureLogging(options Options) {
-	stdout := options.Stdout
-	if stdout == nil {
-		stdout = ioutil.Discard
+	if len(parts) != 2 {
+		return "", "", errors.New("wrong dir format for " + paths + " (expected \"local:remote\")")
 	}
-	logs.Progress = log.New(stdout, LogPrefix, log.LstdFlags)
-	StepLogger = log.New(stdout, LogPrefix, log.LstdFlags)
+	return parts[0], parts[1], nil
+}
 
-	stderr := options.Stderr
-	if stderr == nil {
-		stderr = ioutil.Discard
+func tarPackage(name, targetPath string, recursive bool) (file string, err error) {
+	source, err := HostSource(name)
+	if err != nil {
+		return "", err
 	}
-	logs.Warn = log.New(stderr, LogPrefix, log.LstdFlags)
+	return tarPackageSources([]Source{source}, targetPath, true, recursive)
 }
 
-func tarPackage(name, targetPath string, recursive bool) (file string, err error) {
+// tarPackageSources packages the files and directories into a layer tar file. Files are copied into the target
+// path if it's a directory, or as the target path otherwise, while directories always have their content
+// copied into the target path.
+func tarPackageSources(sources []Source, targetPath string, targetDir, recursive bool) (file string, err error) {
 	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
 	if err != nil {
 		return "", err
@@ -128,23 +351,21 @@ func tarPackage(name, targetPath string, recursive bool) (file string, err error
 
 	writer := tar.NewWriter(layerFile)
 	defer writer.Close()
-	fileInfo, err := os.Stat(name)
-	if err != nil {
-		return "", err
-	}
-
-	if !fileInfo.IsDir() {
-		err := writeFileToTar(name, targetPath, writer, fileInfo)
+	for _, source := range sources {
+		fileInfo, err := source.stat()
 		if err != nil {
 			return "", err
 		}
-	} else if recursive {
-		err = tarPackageRecursive(name, targetPath, writer)
-		if err != nil {
-			return "", err
+
+		if !fileInfo.IsDir() && targetDir {
+			err = writeFileToTar(source, targetPath, writer, fileInfo)
+		} else if !fileInfo.IsDir() {
+			err = writeFileToTarAs(source, targetPath, writer, fileInfo)
+		} else if recursive {
+			err = tarPackageRecursive(source, targetPath, writer)
+		} else {
+			err = tarPackageNonRecursive(source, targetPath, writer)
 		}
-	} else {
-		err = tarPackageNonRecursive(name, targetPath, writer)
 		if err != nil {
 			return "", err
 		}
@@ -153,24 +374,24 @@ func tarPackage(name, targetPath string, recursive bool) (file string, err error
 	return layerFile.Name(), nil
 }
 
-func tarPackageNonRecursive(dirName, targetPath string, writer *tar.Writer) error {
-	dir, err := os.Open(dirName)
+func tarPackageNonRecursive(dir Source, targetPath string, writer *tar.Writer) error {
+	entries, err := fs.ReadDir(dir.FS, dir.Path)
 	if err != nil {
 		return err
 	}
 
-	files, err := dir.Readdir(0)
-	if err != nil {
-		return err
-	}
-
-	for _, fileInfo := range files {
+	for _, entry := range entries {
+		source := dir.join(entry.Name())
+		fileInfo, err := source.stat()
+		if err != nil {
+			return err
+		}
 
 		if fileInfo.IsDir() {
 			continue
 		}
 
-		err := writeFileToTar(dir.Name()+string(filepath.Separator)+fileInfo.Name(), targetPath, writer, fileInfo)
+		err = writeFileToTar(source, targetPath, writer, fileInfo)
 		if err != nil {
 			return err
 		}
@@ -178,18 +399,18 @@ func tarPackageNonRecursive(dirName, targetPath string, writer *tar.Writer) erro
 	return nil
 }
 
-func writeFileToTar(name, targetPath string, writer *tar.Writer, fileInfo fs.FileInfo) error {
-	file, err := os.Open(name)
+func writeFileToTar(source Source, targetPath string, writer *tar.Writer, fileInfo fs.FileInfo) error {
+	return writeFileToTarAs(source, path.Join(targetPath, path.Base(source.Path)), writer, fileInfo)
+}
+
+func writeFileToTarAs(source Source, targetName string, writer *tar.Writer, fileInfo fs.FileInfo) error {
+	file, err := source.FS.Open(source.Path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	header := prepareHeader(
-		targetPath,
-		path.Join(targetPath, filepath.Base(file.Name())),
-		fileInfo,
-	)
+	header := source.header(targetName, fileInfo)
 
 	err = writer.WriteHeader(header)
 	if err != nil {
@@ -206,58 +427,37 @@ func writeFileToTar(name, targetPath string, writer *tar.Writer, fileInfo fs.Fil
 	return nil
 }
 
-func tarPackageRecursive(dirName, targetPath string, writer *tar.Writer) error {
-
-	filepath.Walk(dirName, func(filePath string, fileInfo os.FileInfo, err error) error {
-		fileRelPath := strings.Replace(filePath, path.Clean(dirName), "", 1)
-		header := prepareHeader(
-			targetPath,
-			path.Join(targetPath, fileRelPath),
-			fileInfo,
-		)
-		if fileInfo.IsDir() {
-			header.Name = header.Name + "/"
-		}
-
-		err = writer.WriteHeader(header)
+func tarPackageRecursive(dir Source, targetPath string, writer *tar.Writer) error {
+	return fs.WalkDir(dir.FS, dir.Path, func(filePath string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !fileInfo.IsDir() {
-			file, err := os.Open(filePath)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return err
+		fileRelPath := ""
+		if filePath != dir.Path {
+			fileRelPath = filePath
+			if dir.Path != "." {
+				fileRelPath = strings.TrimPrefix(filePath, dir.Path+"/")
 			}
 		}
 
-		return nil
-	})
-
-	return nil
-}
-
-func appendPaths(base v1.Image, annotations map[string]string, paths ...string) (v1.Image, error) {
-	additions := make([]mutate.Addendum, 0, len(paths))
-	for idx, path := range paths {
-		layer, err := tarball.LayerFromFile(path)
+		// Symbolic links are followed, directories are walked only if they're not links
+		source := dir
+		source.Path = filePath
+		fileInfo, err := source.stat()
 		if err != nil {
-			return nil, fmt.Errorf("reading tar %q: %v", path, err)
-		}
-		addendum := mutate.Addendum{
-			Layer: layer,
+			return err
 		}
-		if len(annotations) > 0 && idx == len(paths)-1 {
-			addendum.Annotations = annotations
+		if fileInfo.IsDir() && !entry.IsDir() {
+			return nil
 		}
-		additions = append(additions, addendum)
-	}
 
-	return mutate.Append(base, additions...)
+		header := source.header(path.Join(targetPath, fileRelPath), fileInfo)
+		if fileInfo.IsDir() {
+			header.Name = header.Name + "/"
+			header.Typeflag = tar.TypeDir
+			return writer.WriteHeader(header)
+		}
+		return writeFileToTarAs(source, header.Name, writer, fileInfo)
+	})
 }
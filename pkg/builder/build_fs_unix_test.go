@@ -5,10 +5,13 @@ package builder
 
 import (
 	"archive/tar"
+	"bytes"
+	"log/slog"
 	"os"
 	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"golang.org/x/sys/unix"
 
@@ -100,3 +103,33 @@ func TestTarDirAllEntriesRecursive(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, "EOF", err.Error())
 }
+
+func TestPrepareHeaderWarnsWithoutOwner(t *testing.T) {
+	fi, err := fstest.MapFS{"app.jar": &fstest.MapFile{Data: []byte("app"), Mode: 0o644}}.Stat("app.jar")
+	assert.NoError(t, err)
+
+	var warnings bytes.Buffer
+	header := prepareHeader("/deployments", "/deployments/app.jar", fi, slog.New(slog.NewTextHandler(&warnings, nil)))
+	assert.Equal(t, 0, header.Uid)
+	assert.Equal(t, 0, header.Gid)
+	assert.Contains(t, warnings.String(), "Could not read UID/GID")
+	assert.Contains(t, warnings.String(), "path=/deployments/app.jar")
+}
+
+func TestTarRecursiveWarnsWithoutOwner(t *testing.T) {
+	// The files of the map don't tell their owner, unlike the ones of the host file system
+	var warnings bytes.Buffer
+	dir := Source{
+		FS: fstest.MapFS{
+			"lib/dep.jar": &fstest.MapFile{Data: []byte("dep"), Mode: 0o644},
+		},
+		Path:     ".",
+		host:     true,
+		warnings: slog.New(slog.NewTextHandler(&warnings, nil)),
+	}
+
+	tarFileName, err := tarPackageSources([]Source{dir}, "/deployments/", true, true)
+	defer os.Remove(tarFileName)
+	assert.NoError(t, err)
+	assert.Contains(t, warnings.String(), "path=/deployments/lib/dep.jar")
+}
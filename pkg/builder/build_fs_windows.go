@@ -6,22 +6,22 @@ package builder
 import (
 	"archive/tar"
 	"io/fs"
+	"log/slog"
 
 	"golang.org/x/sys/windows"
 )
 
-func prepareHeader(tp, name string, fi fs.FileInfo) *tar.Header {
+func prepareHeader(tp, name string, fi fs.FileInfo, warnings *slog.Logger) *tar.Header {
 	// prepare the tar header
 	header := new(tar.Header)
 	header.Name = name
 	header.Size = fi.Size()
 	header.Mode = int64(fi.Mode().Perm())
-	fileSys := fi.Sys()
-	if fileSys != nil {
+	if fi.Sys() != nil {
 		header.Uid = windows.Getuid()
 		header.Gid = windows.Getgid()
-	} else {
-		StepLogger.Printf("Warning: could not read UID/GID. Assuming default (root) permissions.")
+	} else if warnings != nil {
+		warnings.Warn("Could not read UID/GID, assuming default (root) permissions", "phase", "package", "path", name)
 	}
 	header.ModTime = fi.ModTime()
 
@@ -2,9 +2,14 @@ package builder
 
 import (
 	"archive/tar"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,6 +30,10 @@ func TestPaths(t *testing.T) {
 	_, _, err = getPaths(paths, "linux")
 	assert.Error(t, err)
 
+	// errors without target
+	_, _, err = getPaths("/home/user/name/dir", "linux")
+	assert.Error(t, err)
+
 	// windows relative path
 	windowsRelPath := "src\\main\\resources"
 	paths = fmt.Sprintf("%s:%s", windowsRelPath, targetPath)
@@ -44,6 +53,56 @@ func TestPaths(t *testing.T) {
 	assert.Equal(t, ".", targetPath)
 }
 
+func TestCopyPaths(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-copy-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	for _, name := range []string{"target/app-runner.jar", "target/lib.jar", "dist/index.html", "dist/js/app.js"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0o644))
+	}
+
+	tests := []struct {
+		name       string
+		localPath  string
+		targetPath string
+		recursive  bool
+		expected   []string
+		err        bool
+	}{
+		{name: "file into directory", localPath: "target/app-runner.jar", targetPath: "/deployments/", expected: []string{"/deployments/app-runner.jar"}},
+		{name: "file into current directory", localPath: "target/app-runner.jar", targetPath: ".", expected: []string{"/app-runner.jar"}},
+		{name: "file renamed", localPath: "target/app-runner.jar", targetPath: "/deployments/app.jar", expected: []string{"/deployments/app.jar"}},
+		{name: "glob renamed", localPath: "target/*-runner.jar", targetPath: "/deployments/app.jar", expected: []string{"/deployments/app.jar"}},
+		{name: "glob into directory", localPath: "target/*.jar", targetPath: "/deployments/", expected: []string{"/deployments/app-runner.jar", "/deployments/lib.jar"}},
+		{name: "glob into file", localPath: "target/*.jar", targetPath: "/deployments/app.jar", err: true},
+		{name: "glob without matches", localPath: "target/*.war", targetPath: "/deployments/", err: true},
+		{name: "directory", localPath: "dist", targetPath: "/app", expected: []string{"/app/index.html"}},
+		{name: "directory recursive", localPath: "dist", targetPath: "/app/", recursive: true, expected: []string{"/app", "/app/index.html", "/app/js", "/app/js/app.js"}},
+		{name: "glob directories", localPath: "*", targetPath: "/app/", expected: []string{"/app/index.html", "/app/app-runner.jar", "/app/lib.jar"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tarFile, err := copyPackage(filepath.Join(tmpDir, test.localPath), test.targetPath, test.recursive, nil)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			defer os.Remove(tarFile)
+
+			headers, err := readHeaders(tarFile)
+			assert.NoError(t, err)
+			names := make([]string, 0, len(headers))
+			for name := range headers {
+				names = append(names, name)
+			}
+			assert.ElementsMatch(t, test.expected, names)
+		})
+	}
+}
+
 func TestTarSingleEntry(t *testing.T) {
 	var tmpFile1 *os.File
 	var err error
@@ -130,3 +189,71 @@ func TestTarDirRecursive(t *testing.T) {
 	assert.True(t, strings.HasPrefix(header.Name, "/path/to/target/dir2"))
 	assert.True(t, strings.HasSuffix(header.Name, ".txt"))
 }
+
+func TestConcurrentBuildsLogging(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-build-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	const builds = 8
+	sink := &MemorySink{}
+	outputs := make([]bytes.Buffer, builds)
+	var wg sync.WaitGroup
+	for i := 0; i < builds; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := Build(Options{
+				Target: fmt.Sprintf("mem:app-%d", i),
+				Sinks:  map[string]Sink{"mem": sink},
+				Stdout: &outputs[i],
+				RunAs:  fmt.Sprintf("user-%d", i),
+			}, tmpDir+":/deployments")
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < builds; i++ {
+		output := outputs[i].String()
+		assert.Contains(t, output, fmt.Sprintf(`msg="Setting user" phase=config user=user-%d`+"\n", i))
+		assert.Contains(t, output, fmt.Sprintf(`msg="Writing image" phase=push image=mem:app-%d`+"\n", i))
+		assert.Equal(t, 1, strings.Count(output, "Setting user"), output)
+	}
+}
+
+func TestBuildStructuredLogs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-build-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	var output bytes.Buffer
+	digest, err := Build(Options{
+		Target: "mem:app",
+		Sinks:  map[string]Sink{"mem": &MemorySink{}},
+		Logger: slog.New(slog.NewJSONHandler(&output, nil)),
+	}, tmpDir+":/deployments")
+	assert.NoError(t, err)
+
+	var records []map[string]interface{}
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		record := make(map[string]interface{})
+		assert.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+	phases := make([]interface{}, 0, len(records))
+	for _, record := range records {
+		phases = append(phases, record["phase"])
+	}
+	assert.Equal(t, []interface{}{"pull", "pull", "package", "package", "push", "push"}, phases)
+
+	packaged := records[3]
+	assert.Equal(t, "Packaged layer", packaged["msg"])
+	assert.Equal(t, tmpDir, packaged["source"])
+	assert.Contains(t, packaged["digest"], "sha256:")
+	assert.NotZero(t, packaged["bytes"])
+	assert.Equal(t, digest, records[5]["digest"])
+}
@@ -0,0 +1,163 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// LayerCache keeps the layers packaged from the mappings across the builds using it, so that a build only packages
+// again the mappings whose local sources changed, e.g. when rebuilding on changes. Layers of other images are reused
+// as they are. It must be closed to remove the packaged layers.
+type LayerCache struct {
+	mu     sync.Mutex
+	layers map[string]cachedLayer
+}
+
+type cachedLayer struct {
+	fingerprint string
+	addendum    mutate.Addendum
+	tarFile     string
+}
+
+// NewLayerCache creates an empty cache
+func NewLayerCache() *LayerCache {
+	return &LayerCache{layers: make(map[string]cachedLayer)}
+}
+
+// Close removes the packaged layers
+func (c *LayerCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, layer := range c.layers {
+		if layer.tarFile != "" {
+			os.Remove(layer.tarFile)
+		}
+		delete(c.layers, key)
+	}
+	return nil
+}
+
+func (c *LayerCache) get(key string, fingerprint string) (mutate.Addendum, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	layer, ok := c.layers[key]
+	if !ok || layer.fingerprint != fingerprint {
+		return mutate.Addendum{}, false
+	}
+	return layer.addendum, true
+}
+
+func (c *LayerCache) put(key string, fingerprint string, addendum mutate.Addendum, tarFile string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.layers[key]; ok && previous.tarFile != "" && previous.tarFile != tarFile {
+		os.Remove(previous.tarFile)
+	}
+	c.layers[key] = cachedLayer{fingerprint: fingerprint, addendum: addendum, tarFile: tarFile}
+}
+
+// packageCachedMapping packages the mapping, or reuses the layer packaged by a previous build if its sources didn't
+// change. The returned tar file, if any, is owned by the caller, while the ones of cached layers are owned by the
+// cache.
+func packageCachedMapping(mapping Mapping, options Options) (addendum mutate.Addendum, tarFile string, reused bool, err error) {
+	cache := options.LayerCache
+	if cache == nil || mapping.FS != nil || mapping.Source == StdinSource {
+		addendum, tarFile, err = packageMapping(mapping, options)
+		return addendum, tarFile, false, err
+	}
+
+	key, err := mappingKey(mapping, options)
+	if err != nil {
+		return mutate.Addendum{}, "", false, err
+	}
+	fingerprint := ""
+	if localPath, ok := LocalPath(mapping); ok {
+		if fingerprint, err = sourcesFingerprint(localPath); err != nil {
+			// Let the packaging report the missing sources
+			addendum, tarFile, err = packageMapping(mapping, options)
+			return addendum, tarFile, false, err
+		}
+	}
+	if addendum, ok := cache.get(key, fingerprint); ok {
+		return addendum, "", true, nil
+	}
+
+	addendum, tarFile, err = packageMapping(mapping, options)
+	if err != nil {
+		return addendum, tarFile, false, err
+	}
+	cache.put(key, fingerprint, addendum, tarFile)
+	return addendum, "", false, nil
+}
+
+// LocalPath returns the local file, directory or glob pattern the mapping is packaged from, if any
+func LocalPath(mapping Mapping) (string, bool) {
+	switch {
+	case mapping.FS != nil || mapping.Source == StdinSource || strings.HasPrefix(mapping.Source, ImageSourcePrefix):
+		return "", false
+	case strings.HasPrefix(mapping.Source, ArchiveSourcePrefix):
+		return strings.TrimPrefix(mapping.Source, ArchiveSourcePrefix), true
+	case strings.HasPrefix(mapping.Source, LayerSourcePrefix):
+		layerPath, _, _, err := getLayerSpec(mapping.Source)
+		return layerPath, err == nil
+	default:
+		return mapping.Source, true
+	}
+}
+
+// mappingKey identifies the layer packaged from the mapping
+func mappingKey(mapping Mapping, options Options) (string, error) {
+	recursive := options.Recursive
+	if mapping.Recursive != nil {
+		recursive = *mapping.Recursive
+	}
+	key, err := json.Marshal(struct {
+		Source      string
+		Destination string
+		UID         *int
+		GID         *int
+		Mode        *fs.FileMode
+		Exclude     []string
+		Recursive   bool
+	}{mapping.Source, mapping.Destination, mapping.UID, mapping.GID, mapping.Mode, mapping.Exclude, recursive})
+	return string(key), err
+}
+
+// sourcesFingerprint summarizes the names, sizes, modes and modification times of the files matching the local
+// path, to tell whether they changed
+func sourcesFingerprint(localPath string) (string, error) {
+	names := []string{localPath}
+	if strings.ContainsAny(localPath, "*?[") {
+		var err error
+		if names, err = filepath.Glob(localPath); err != nil {
+			return "", err
+		}
+	}
+	hash := sha256.New()
+	for _, name := range names {
+		err := filepath.WalkDir(name, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\x00%d\x00%o\x00%d\n", p, info.Size(), info.Mode(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
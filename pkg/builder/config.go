@@ -0,0 +1,102 @@
+package builder
+
+import (
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// configure applies the changes to the configuration of the image set in the options
+func configure(img v1.Image, options Options) (v1.Image, error) {
+	logger := options.logger()
+	confFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config := confFile.Config
+	changed := false
+
+	if options.ClearEntrypoint {
+		logger.Info("Clearing entrypoint", "phase", "config")
+		config.Entrypoint = nil
+		changed = true
+	}
+	if options.Entrypoint != nil {
+		logger.Info("Setting entrypoint", "phase", "config", "entrypoint", options.Entrypoint)
+		config.Entrypoint = options.Entrypoint
+		changed = true
+	}
+	if options.Cmd != nil {
+		logger.Info("Setting command", "phase", "config", "cmd", options.Cmd)
+		config.Cmd = options.Cmd
+		changed = true
+	}
+	if options.RunAs != "" {
+		logger.Info("Setting user", "phase", "config", "user", options.RunAs)
+		config.User = options.RunAs
+		changed = true
+	}
+	if options.WorkingDir != "" {
+		logger.Info("Setting working directory", "phase", "config", "dir", options.WorkingDir)
+		config.WorkingDir = options.WorkingDir
+		changed = true
+	}
+	if len(options.Env) > 0 {
+		logger.Info("Setting environment variables", "phase", "config", "env", len(options.Env))
+		config.Env = mergeEnv(config.Env, options.Env)
+		changed = true
+	}
+	if len(options.Labels) > 0 {
+		logger.Info("Setting labels", "phase", "config", "labels", len(options.Labels))
+		labels := make(map[string]string, len(config.Labels)+len(options.Labels))
+		for k, v := range config.Labels {
+			labels[k] = v
+		}
+		for k, v := range options.Labels {
+			labels[k] = v
+		}
+		config.Labels = labels
+		changed = true
+	}
+	if len(options.ExposedPorts) > 0 {
+		logger.Info("Exposing ports", "phase", "config", "ports", options.ExposedPorts)
+		ports := make(map[string]struct{}, len(config.ExposedPorts)+len(options.ExposedPorts))
+		for port := range config.ExposedPorts {
+			ports[port] = struct{}{}
+		}
+		for _, port := range options.ExposedPorts {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			ports[port] = struct{}{}
+		}
+		config.ExposedPorts = ports
+		changed = true
+	}
+
+	if !changed {
+		return img, nil
+	}
+	return mutate.Config(img, config)
+}
+
+// mergeEnv sets the variables in the KEY=value format, replacing the existing ones with the same key in place
+func mergeEnv(env []string, variables []string) []string {
+	merged := append([]string(nil), env...)
+	for _, variable := range variables {
+		key, _, _ := strings.Cut(variable, "=")
+		replaced := false
+		for i, existing := range merged {
+			if existingKey, _, _ := strings.Cut(existing, "="); existingKey == key {
+				merged[i] = variable
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, variable)
+		}
+	}
+	return merged
+}
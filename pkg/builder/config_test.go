@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigure(t *testing.T) {
+	base, err := mutate.Config(empty.Image, v1.Config{
+		Entrypoint:   []string{"/docker-entrypoint.sh"},
+		Cmd:          []string{"nginx"},
+		Env:          []string{"PATH=/usr/bin", "LANG=C"},
+		Labels:       map[string]string{"maintainer": "base"},
+		ExposedPorts: map[string]struct{}{"80/tcp": {}},
+	})
+	assert.NoError(t, err)
+
+	img, err := configure(base, Options{
+		Entrypoint:   []string{"java", "-jar", "/deployments/app.jar"},
+		Cmd:          []string{},
+		WorkingDir:   "/deployments",
+		Env:          []string{"LANG=C.UTF-8", "JAVA_OPTS=-Xmx1g"},
+		Labels:       map[string]string{"version": "1.0"},
+		ExposedPorts: []string{"8080", "8443/tcp", "9000/udp"},
+		RunAs:        "185",
+	})
+	assert.NoError(t, err)
+	confFile, err := img.ConfigFile()
+	assert.NoError(t, err)
+	config := confFile.Config
+	assert.Equal(t, []string{"java", "-jar", "/deployments/app.jar"}, config.Entrypoint)
+	assert.Empty(t, config.Cmd)
+	assert.Equal(t, "/deployments", config.WorkingDir)
+	assert.Equal(t, "185", config.User)
+	assert.Equal(t, []string{"PATH=/usr/bin", "LANG=C.UTF-8", "JAVA_OPTS=-Xmx1g"}, config.Env)
+	assert.Equal(t, map[string]string{"maintainer": "base", "version": "1.0"}, config.Labels)
+	assert.Equal(t, map[string]struct{}{"80/tcp": {}, "8080/tcp": {}, "8443/tcp": {}, "9000/udp": {}}, config.ExposedPorts)
+
+	unchanged, err := configure(base, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, base, unchanged)
+}
@@ -0,0 +1,133 @@
+package builder
+
+import (
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// Events receives notifications while a build runs, e.g. to publish them as Kubernetes events or status conditions.
+// Methods are called while the build runs, OnUploadProgress from a separate goroutine, so they should return quickly.
+type Events interface {
+	// OnPullStart is called before pulling the base image
+	OnPullStart(image string)
+	// OnLayerPackaged is called for each layer added to the image, with the source it was packaged from
+	OnLayerPackaged(source string, digest v1.Hash, size int64)
+	// OnUploadProgress is called as the image blobs are uploaded to the registry, with the bytes written so far
+	// and the total bytes to write
+	OnUploadProgress(complete, total int64)
+	// OnPushComplete is called once the image has been written to the target
+	OnPushComplete(target string, digest v1.Hash)
+}
+
+// LayerProgressEvents can be implemented by the Events to be notified of the progress of each layer
+type LayerProgressEvents interface {
+	// OnLayerProgress is called as each layer is downloaded from or uploaded to a registry, from the goroutine
+	// transferring it
+	OnLayerProgress(progress LayerProgress)
+}
+
+// BuildCompleteEvents can be implemented by the Events to be notified of the end of each build
+type BuildCompleteEvents interface {
+	// OnBuildComplete is called at the end of the build, with the error that made it fail if any. The result
+	// only contains the steps completed before the failure in that case.
+	OnBuildComplete(result *BuildResult, err error)
+}
+
+// NopEvents ignores all the notifications. It can be embedded to implement only some of the Events methods.
+type NopEvents struct{}
+
+func (NopEvents) OnPullStart(image string)                                  {}
+func (NopEvents) OnLayerPackaged(source string, digest v1.Hash, size int64) {}
+func (NopEvents) OnUploadProgress(complete, total int64)                    {}
+func (NopEvents) OnLayerProgress(progress LayerProgress)                    {}
+func (NopEvents) OnPushComplete(target string, digest v1.Hash)              {}
+func (NopEvents) OnBuildComplete(result *BuildResult, err error)            {}
+
+// MultiEvents forwards the notifications to all the given events, in order
+func MultiEvents(events ...Events) Events {
+	return multiEvents(events)
+}
+
+type multiEvents []Events
+
+func (m multiEvents) OnPullStart(image string) {
+	for _, e := range m {
+		e.OnPullStart(image)
+	}
+}
+
+func (m multiEvents) OnLayerPackaged(source string, digest v1.Hash, size int64) {
+	for _, e := range m {
+		e.OnLayerPackaged(source, digest, size)
+	}
+}
+
+func (m multiEvents) OnUploadProgress(complete, total int64) {
+	for _, e := range m {
+		e.OnUploadProgress(complete, total)
+	}
+}
+
+func (m multiEvents) OnLayerProgress(progress LayerProgress) {
+	for _, e := range m {
+		if p, ok := e.(LayerProgressEvents); ok {
+			p.OnLayerProgress(progress)
+		}
+	}
+}
+
+func (m multiEvents) OnPushComplete(target string, digest v1.Hash) {
+	for _, e := range m {
+		e.OnPushComplete(target, digest)
+	}
+}
+
+func (m multiEvents) OnBuildComplete(result *BuildResult, err error) {
+	for _, e := range m {
+		if c, ok := e.(BuildCompleteEvents); ok {
+			c.OnBuildComplete(result, err)
+		}
+	}
+}
+
+func (o Options) events() Events {
+	if o.Events == nil {
+		return NopEvents{}
+	}
+	return o.Events
+}
+
+// layerPackaged logs and notifies the packaged layer
+func layerPackaged(options Options, source string, addendum mutate.Addendum, start time.Time) error {
+	duration := time.Since(start)
+	digest, err := addendum.Layer.Digest()
+	if err != nil {
+		return err
+	}
+	size, err := addendum.Layer.Size()
+	if err != nil {
+		return err
+	}
+	options.logger().Info("Packaged layer", "phase", "package", "source", source, "digest", digest.String(), "bytes", size, "duration", duration)
+	options.result.addLayer(source, digest, size, false)
+	options.events().OnLayerPackaged(source, digest, size)
+	return nil
+}
+
+// layerReused logs and notifies the layer reused from a previous build
+func layerReused(options Options, source string, addendum mutate.Addendum) error {
+	digest, err := addendum.Layer.Digest()
+	if err != nil {
+		return err
+	}
+	size, err := addendum.Layer.Size()
+	if err != nil {
+		return err
+	}
+	options.logger().Info("Reused unchanged layer", "phase", "package", "source", source, "digest", digest.String(), "bytes", size)
+	options.result.addLayer(source, digest, size, true)
+	options.events().OnLayerPackaged(source, digest, size)
+	return nil
+}
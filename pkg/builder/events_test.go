@@ -0,0 +1,177 @@
+package builder
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingEvents struct {
+	NopEvents
+	mu       sync.Mutex
+	pulled   []string
+	packaged []string
+	complete int64
+	total    int64
+	pushed   map[string]v1.Hash
+	progress map[string]LayerProgress
+}
+
+func (e *recordingEvents) OnPullStart(image string) {
+	e.pulled = append(e.pulled, image)
+}
+
+func (e *recordingEvents) OnLayerPackaged(source string, digest v1.Hash, size int64) {
+	e.packaged = append(e.packaged, source)
+}
+
+func (e *recordingEvents) OnUploadProgress(complete, total int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.complete = complete
+	e.total = total
+}
+
+func (e *recordingEvents) OnLayerProgress(progress LayerProgress) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.progress == nil {
+		e.progress = make(map[string]LayerProgress)
+	}
+	e.progress[progress.Operation+" "+progress.Digest.String()] = progress
+}
+
+func (e *recordingEvents) OnPushComplete(target string, digest v1.Hash) {
+	if e.pushed == nil {
+		e.pushed = make(map[string]v1.Hash)
+	}
+	e.pushed[target] = digest
+}
+
+func TestBuildEvents(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "spectrum-events-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	events := &recordingEvents{}
+	target := strings.TrimPrefix(server.URL, "http://") + "/myapp:1.0"
+	digest, err := Build(Options{
+		Target:       target,
+		PushInsecure: true,
+		Remove:       []string{"/tmp/"},
+		Events:       events,
+	}, tmpDir+":/deployments")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{""}, events.pulled)
+	assert.Equal(t, []string{"remove", tmpDir}, events.packaged)
+	assert.NotZero(t, events.total)
+	assert.Equal(t, events.total, events.complete)
+	assert.Equal(t, digest, events.pushed[target].String())
+}
+
+func TestBuildLayerProgress(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	base := strings.TrimPrefix(server.URL, "http://") + "/base:1.0"
+	ref, err := name.ParseReference(base)
+	assert.NoError(t, err)
+	baseImage := testImage(t, writeTestTar(t, testEntry{name: "/opt/base.txt", content: "base"}))
+	assert.NoError(t, remote.Write(ref, baseImage))
+	baseLayers, err := baseImage.Layers()
+	assert.NoError(t, err)
+	baseDigest, err := baseLayers[0].Digest()
+	assert.NoError(t, err)
+
+	events := &recordingEvents{}
+	target := strings.TrimPrefix(server.URL, "http://") + "/myapp:1.0"
+	_, err = Build(Options{
+		Base:         base,
+		Target:       target,
+		PullInsecure: true,
+		PushInsecure: true,
+		Squash:       true,
+		Files:        []File{{Path: "/opt/app.txt", Content: []byte("app")}},
+		Events:       events,
+	})
+	assert.NoError(t, err)
+
+	pulled, ok := events.progress["pull "+baseDigest.String()]
+	assert.True(t, ok)
+	assert.NotZero(t, pulled.Total)
+	assert.Equal(t, pulled.Total, pulled.Complete)
+
+	ref, err = name.ParseReference(target)
+	assert.NoError(t, err)
+	img, err := remote.Image(ref)
+	assert.NoError(t, err)
+	layers, err := img.Layers()
+	assert.NoError(t, err)
+	assert.Len(t, layers, 1)
+	squashedDigest, err := layers[0].Digest()
+	assert.NoError(t, err)
+	pushed, ok := events.progress["push "+squashedDigest.String()]
+	assert.True(t, ok)
+	assert.NotZero(t, pushed.Total)
+	assert.Equal(t, pushed.Total, pushed.Complete)
+}
+
+// minimalEvents implements only the methods required by Events, without embedding NopEvents
+type minimalEvents struct {
+	pushed []string
+}
+
+func (e *minimalEvents) OnPullStart(image string)                                  {}
+func (e *minimalEvents) OnLayerPackaged(source string, digest v1.Hash, size int64) {}
+func (e *minimalEvents) OnUploadProgress(complete, total int64)                    {}
+func (e *minimalEvents) OnPushComplete(target string, digest v1.Hash) {
+	e.pushed = append(e.pushed, target)
+}
+
+func TestBuildMinimalEvents(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	events := &minimalEvents{}
+	target := strings.TrimPrefix(server.URL, "http://") + "/myapp:1.0"
+	_, err := Build(Options{
+		Target:       target,
+		PushInsecure: true,
+		Files:        []File{{Path: "/opt/app.txt", Content: []byte("app")}},
+		Events:       MultiEvents(events),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{target}, events.pushed)
+}
+
+func TestLayersListedWithoutListeners(t *testing.T) {
+	// Neither events, info logs, report nor tracing: the layers are still part of the result
+	result, err := BuildContext(context.Background(), Options{
+		Target: "mem:app",
+		Sinks:  map[string]Sink{"mem": &MemorySink{}},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError})),
+		Files:  []File{{Path: "/opt/app.txt", Content: []byte("app")}},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Layers, 1) {
+		assert.NotEmpty(t, result.Layers[0].Digest)
+		assert.Positive(t, result.Layers[0].Size)
+	}
+}
@@ -0,0 +1,106 @@
+package builder
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StdinSource is the local path of mappings reading a tar stream from the standard input, e.g. -:/deployments
+const StdinSource = "-"
+
+const defaultFileMode fs.FileMode = 0o644
+
+// File describes a single file to be created in the image, either with the given content or copied
+// from a local file
+type File struct {
+	// Path is the absolute path of the file in the image
+	Path string
+	// Content is the literal content of the file, used when no source is set
+	Content []byte
+	// Source is the local file to copy
+	Source string
+	// Mode, if set, is the permission of the file. It defaults to 0644 for literal content and to the
+	// permission of the source file otherwise
+	Mode *fs.FileMode
+}
+
+// filesPackage creates a layer tar file containing the given files, logging the warnings to the given logger, if set
+func filesPackage(files []File, warnings *slog.Logger) (file string, err error) {
+	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	writer := tar.NewWriter(layerFile)
+	defer writer.Close()
+
+	for _, f := range files {
+		if !path.IsAbs(f.Path) || path.Base(f.Path) == "/" {
+			return layerFile.Name(), errors.New("wrong path for file " + f.Path + ": expected an absolute file path")
+		}
+		if f.Source != "" {
+			err = writeSourceFileToTar(f, writer, warnings)
+		} else {
+			err = writeContentToTar(f, writer)
+		}
+		if err != nil {
+			return layerFile.Name(), errors.Wrapf(err, "cannot package file %s", f.Path)
+		}
+	}
+
+	return layerFile.Name(), nil
+}
+
+func writeSourceFileToTar(f File, writer *tar.Writer, warnings *slog.Logger) error {
+	file, err := os.Open(f.Source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return errors.New(f.Source + " is not a regular file")
+	}
+
+	header := prepareHeader(path.Dir(f.Path), f.Path, fileInfo, warnings)
+	if f.Mode != nil {
+		header.Mode = int64(f.Mode.Perm())
+	}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+func writeContentToTar(f File, writer *tar.Writer) error {
+	mode := defaultFileMode
+	if f.Mode != nil {
+		mode = *f.Mode
+	}
+	header := &tar.Header{
+		Name:     f.Path,
+		Typeflag: tar.TypeReg,
+		Mode:     int64(mode.Perm()),
+		Size:     int64(len(f.Content)),
+		ModTime:  time.Unix(0, 0),
+	}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := writer.Write(f.Content)
+	return err
+}
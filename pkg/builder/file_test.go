@@ -0,0 +1,80 @@
+package builder
+
+import (
+	"archive/tar"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilesPackage(t *testing.T) {
+	source, err := os.CreateTemp("", "spectrum-file-*.conf")
+	assert.NoError(t, err)
+	defer os.Remove(source.Name())
+	_, err = source.WriteString("from file")
+	assert.NoError(t, err)
+	assert.NoError(t, source.Close())
+	assert.NoError(t, os.Chmod(source.Name(), 0o600))
+
+	secretMode, runMode, placeholderMode := fs.FileMode(0o400), fs.FileMode(0o755), fs.FileMode(0)
+	tarFile, err := filesPackage([]File{
+		{Path: "/etc/app/literal.conf", Content: []byte("literal")},
+		{Path: "/etc/app/secret.conf", Content: []byte("secret"), Mode: &secretMode},
+		{Path: "/etc/app/file.conf", Source: source.Name()},
+		{Path: "/etc/app/run.sh", Source: source.Name(), Mode: &runMode},
+		{Path: "/etc/app/placeholder.conf", Content: []byte{}, Mode: &placeholderMode},
+	}, nil)
+	defer os.Remove(tarFile)
+	assert.NoError(t, err)
+
+	headers := readTestHeaders(t, tarFile)
+	assert.Len(t, headers, 5)
+	assert.Equal(t, int64(0o644), headers["/etc/app/literal.conf"].Mode)
+	assert.Equal(t, int64(len("literal")), headers["/etc/app/literal.conf"].Size)
+	assert.Equal(t, int64(0o400), headers["/etc/app/secret.conf"].Mode)
+	assert.Equal(t, int64(0o600), headers["/etc/app/file.conf"].Mode)
+	assert.Equal(t, int64(len("from file")), headers["/etc/app/file.conf"].Size)
+	assert.Equal(t, int64(0o755), headers["/etc/app/run.sh"].Mode)
+	assert.Equal(t, int64(0), headers["/etc/app/placeholder.conf"].Mode)
+
+	file, err := os.Open(tarFile)
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, map[string]string{
+		"/etc/app/literal.conf":     "literal",
+		"/etc/app/secret.conf":      "secret",
+		"/etc/app/file.conf":        "from file",
+		"/etc/app/run.sh":           "from file",
+		"/etc/app/placeholder.conf": "",
+	}, readTestTar(t, file))
+
+	for _, f := range []File{
+		{Path: "etc/app.conf"},
+		{Path: "/"},
+		{Path: "/etc/app.conf", Source: os.TempDir()},
+	} {
+		tarFile, err := filesPackage([]File{f}, nil)
+		os.Remove(tarFile)
+		assert.Error(t, err, f.Path)
+	}
+}
+
+func TestStreamPackage(t *testing.T) {
+	archive := writeTestArchive(t, true,
+		&tar.Header{Name: "lib/", Typeflag: tar.TypeDir, Mode: 0o755},
+		&tar.Header{Name: "lib/app.jar", Typeflag: tar.TypeReg, Mode: 0o644, Size: 3},
+	)
+	stdin, err := os.Open(archive)
+	assert.NoError(t, err)
+	defer stdin.Close()
+
+	tarFile, err := streamPackage(stdin, "/deployments")
+	defer os.Remove(tarFile)
+	assert.NoError(t, err)
+
+	headers := readTestHeaders(t, tarFile)
+	assert.Len(t, headers, 2)
+	assert.Equal(t, int64(3), headers["/deployments/lib/app.jar"].Size)
+}
@@ -14,14 +14,22 @@ func Pull(options Options) (v1.Image, error) {
 	if options.Base == "" || options.Base == "scratch" {
 		return empty.Image, nil
 	}
+	return pullImage(options.Base, options)
+}
+
+func pullImage(image string, options Options) (v1.Image, error) {
 	nameOptions := makeNameOptions(options.PullInsecure)
-	ref, err := name.ParseReference(options.Base, nameOptions...)
+	ref, err := name.ParseReference(image, nameOptions...)
 	if err != nil {
-		return nil, fmt.Errorf("parsing tag %q: %v", options.Base, err)
+		return nil, fmt.Errorf("parsing tag %q: %v", image, err)
 	}
 
 	remoteOptions := makeRemoteOptions(options, options.PullConfigDir)
-	return remote.Image(ref, remoteOptions...)
+	img, err := remote.Image(ref, remoteOptions...)
+	if err != nil || options.Events == nil {
+		return img, err
+	}
+	return withProgress(img, PullOperation, options.Events, nil), nil
 }
 
 func Push(img v1.Image, options Options) error {
@@ -32,7 +40,29 @@ func Push(img v1.Image, options Options) error {
 	}
 
 	remoteOptions := makeRemoteOptions(options, options.PushConfigDir)
-	return remote.Write(tag, img, remoteOptions...)
+	stats := &transferStats{}
+	layersImg := img
+	img = withProgress(img, PushOperation, options.events(), stats)
+	if options.Events != nil {
+		updates := make(chan v1.Update, 16)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for update := range updates {
+				options.Events.OnUploadProgress(update.Complete, update.Total)
+			}
+		}()
+		defer func() { <-done }()
+		remoteOptions = append(remoteOptions, remote.WithProgress(updates))
+	}
+	if err := remote.Write(tag, img, remoteOptions...); err != nil {
+		return err
+	}
+	if options.result != nil {
+		options.result.Registry = tag.RegistryStr()
+		return stats.record(layersImg, options.result)
+	}
+	return nil
 }
 
 func makeNameOptions(insecure bool) (nameOptions []name.Option) {
@@ -43,6 +73,7 @@ func makeNameOptions(insecure bool) (nameOptions []name.Option) {
 }
 
 func makeRemoteOptions(options Options, configDir string) (remoteOptions []remote.Option) {
+	remoteOptions = append(remoteOptions, remote.WithContext(options.context()), remote.WithTransport(tracingTransport(options)))
 	if options.Jobs > 0 {
 		remoteOptions = append(remoteOptions, remote.WithJobs(options.Jobs))
 	}
@@ -0,0 +1,176 @@
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImageSourcePrefix identifies mappings copying files out of another image,
+// e.g. image://registry/tools:1.0!/usr/bin/helper:/usr/local/bin
+const ImageSourcePrefix = "image://"
+
+const maxSymlinks = 255
+
+func getImageSource(source string) (image string, sourcePath string, err error) {
+	parts := strings.Split(strings.TrimPrefix(source, ImageSourcePrefix), "!")
+	if len(parts) != 2 || parts[0] == "" || !path.IsAbs(parts[1]) {
+		return "", "", errors.New("wrong image format for " + source + " (expected \"image://image!/path\")")
+	}
+	return parts[0], parts[1], nil
+}
+
+// imagePackage extracts the source path from the merged filesystem of the given image into a layer tar file.
+// A directory is copied with all of its content into the target path, while a file is copied into it.
+func imagePackage(image, sourcePath, targetPath string, options Options) (file string, err error) {
+	img, err := pullImage(image, options)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not pull image %s", image)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return "", err
+	}
+	fsFile, err := flattenLayers(layers, false)
+	if fsFile != "" {
+		defer os.Remove(fsFile)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "could not extract filesystem of image %s", image)
+	}
+
+	headers, err := readHeaders(fsFile)
+	if err != nil {
+		return "", err
+	}
+	source, err := resolveSymlinks(headers, path.Clean(sourcePath))
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot resolve %s in image %s", sourcePath, image)
+	}
+	header, ok := headers[source]
+	if !ok {
+		return "", fmt.Errorf("path %s not found in image %s", sourcePath, image)
+	}
+	target := targetPath
+	if header.Typeflag != tar.TypeDir {
+		target = path.Join(targetPath, path.Base(sourcePath))
+	}
+
+	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	writer := tar.NewWriter(layerFile)
+	defer writer.Close()
+
+	fs, err := os.Open(fsFile)
+	if err != nil {
+		return layerFile.Name(), err
+	}
+	defer fs.Close()
+
+	reader := tar.NewReader(fs)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return layerFile.Name(), err
+		}
+
+		name, ok := relocate(path.Clean("/"+header.Name), source, target)
+		if !ok {
+			continue
+		}
+		if header.Typeflag == tar.TypeLink {
+			linkname, ok := relocate(path.Clean("/"+header.Linkname), source, target)
+			if !ok {
+				options.warnings().Warn("Skipping hard link outside of the copied path", "phase", "package", "image", image, "path", header.Name, "link", header.Linkname, "source", sourcePath)
+				continue
+			}
+			header.Linkname = linkname
+		}
+		header.Name = name
+		if header.Typeflag == tar.TypeDir {
+			header.Name = header.Name + "/"
+		}
+		header.Format = tar.FormatPAX
+
+		if err := writer.WriteHeader(header); err != nil {
+			return layerFile.Name(), err
+		}
+		if _, err := io.Copy(writer, reader); err != nil {
+			return layerFile.Name(), err
+		}
+	}
+
+	return layerFile.Name(), nil
+}
+
+func readHeaders(tarFile string) (map[string]*tar.Header, error) {
+	file, err := os.Open(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	headers := make(map[string]*tar.Header)
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return headers, nil
+		} else if err != nil {
+			return nil, err
+		}
+		headers[path.Clean("/"+header.Name)] = header
+	}
+}
+
+// resolveSymlinks evaluates the symbolic links contained in the given absolute path within the image filesystem
+func resolveSymlinks(headers map[string]*tar.Header, p string) (string, error) {
+	links := 0
+	resolved := "/"
+	remaining := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for len(remaining) > 0 {
+		current := path.Join(resolved, remaining[0])
+		remaining = remaining[1:]
+
+		header, ok := headers[current]
+		if !ok || header.Typeflag != tar.TypeSymlink {
+			resolved = current
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", errors.New("too many links")
+		}
+		link := header.Linkname
+		if !path.IsAbs(link) {
+			link = path.Join(resolved, link)
+		}
+		remaining = append(strings.Split(strings.TrimPrefix(path.Clean(link), "/"), "/"), remaining...)
+		resolved = "/"
+	}
+	return resolved, nil
+}
+
+// relocate moves the entry from the source to the target path, if it's contained in the source path
+func relocate(entry, source, target string) (string, bool) {
+	if entry == source {
+		return target, true
+	}
+	if rel := strings.TrimPrefix(entry, strings.TrimSuffix(source, "/")+"/"); rel != entry {
+		return path.Join(target, rel), true
+	}
+	return "", false
+}
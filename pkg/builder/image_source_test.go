@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageSource(t *testing.T) {
+	mapping, err := ParseMapping("image://localhost:5000/tools:1.0!/usr/bin/helper:/usr/local/bin")
+	assert.NoError(t, err)
+	assert.Equal(t, "/usr/local/bin", mapping.Destination)
+	image, sourcePath, err := getImageSource(mapping.Source)
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:5000/tools:1.0", image)
+	assert.Equal(t, "/usr/bin/helper", sourcePath)
+
+	for _, spec := range []string{
+		"image://tools:1.0",
+		"image://tools:1.0!/usr/bin/helper",
+	} {
+		_, err = ParseMapping(spec)
+		assert.Error(t, err, spec)
+	}
+	for _, source := range []string{
+		"image://tools:1.0",
+		"image://!/usr/bin/helper",
+		"image://tools:1.0!usr/bin/helper",
+	} {
+		_, _, err = getImageSource(source)
+		assert.Error(t, err, source)
+	}
+}
+
+func TestImagePackage(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	image := strings.TrimPrefix(server.URL, "http://") + "/tools:1.0"
+	ref, err := name.ParseReference(image)
+	assert.NoError(t, err)
+	assert.NoError(t, remote.Write(ref, testImage(t,
+		writeTestTar(t,
+			testEntry{name: "usr/", dir: true},
+			testEntry{name: "usr/bin/", dir: true},
+			testEntry{name: "usr/bin/jcmd", linkname: "../lib/jvm/bin/jcmd"},
+			testEntry{name: "usr/lib/", dir: true},
+			testEntry{name: "usr/lib/jvm/", dir: true},
+			testEntry{name: "usr/lib/jvm/bin/", dir: true},
+			testEntry{name: "usr/lib/jvm/bin/jcmd", content: "jcmd"},
+			testEntry{name: "opt/", dir: true},
+			testEntry{name: "opt/helper/", dir: true},
+			testEntry{name: "opt/helper/helper.sh", content: "helper"},
+			testEntry{name: "opt/helper/lib/", dir: true},
+			testEntry{name: "opt/helper/lib/helper.jar", content: "jar"},
+		),
+		writeTestTar(t,
+			testEntry{name: "opt/helper/lib/.wh.helper.jar"},
+		),
+	)))
+
+	options := Options{PullInsecure: true}
+	tarFile, err := imagePackage(image, "/usr/bin/jcmd", "/usr/local/bin", options)
+	defer os.Remove(tarFile)
+	assert.NoError(t, err)
+	file, err := os.Open(tarFile)
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, map[string]string{
+		"/usr/local/bin/jcmd": "jcmd",
+	}, readTestTar(t, file))
+
+	tarFile, err = imagePackage(image, "/opt/helper", "/deployments/helper", options)
+	defer os.Remove(tarFile)
+	assert.NoError(t, err)
+	file, err = os.Open(tarFile)
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, map[string]string{
+		"/deployments/helper/":          "",
+		"/deployments/helper/helper.sh": "helper",
+		"/deployments/helper/lib/":      "",
+	}, readTestTar(t, file))
+
+	_, err = imagePackage(image, "/opt/missing", "/deployments", options)
+	assert.Error(t, err)
+}
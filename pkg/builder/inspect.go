@@ -0,0 +1,72 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Inspection describes an image or an index of a registry
+type Inspection struct {
+	// Reference is the inspected reference
+	Reference string `json:"reference"`
+	// Digest is the digest of the manifest of the image or of the index
+	Digest string `json:"digest"`
+	// MediaType is the media type of the manifest of the image or of the index
+	MediaType types.MediaType `json:"mediaType"`
+	// Size is the size of the manifest of the image or of the index
+	Size int64 `json:"size"`
+	// Manifest is the manifest of the image, nil for indexes
+	Manifest *v1.Manifest `json:"manifest,omitempty"`
+	// Config is the configuration of the image, including its history, nil for indexes
+	Config *v1.ConfigFile `json:"config,omitempty"`
+	// Index is the manifest of the index, listing the images of each platform, nil for images
+	Index *v1.IndexManifest `json:"index,omitempty"`
+}
+
+// Inspect fetches the manifest and the configuration of an image, or the manifest of an index, authenticating
+// as when pulling the base image
+func Inspect(ctx context.Context, image string, options Options) (*Inspection, error) {
+	options.ctx = ctx
+	ref, err := name.ParseReference(image, makeNameOptions(options.PullInsecure)...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing reference %q: %v", image, err)
+	}
+	descriptor, err := remote.Get(ref, makeRemoteOptions(options, options.PullConfigDir)...)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &Inspection{
+		Reference: ref.String(),
+		Digest:    descriptor.Digest.String(),
+		MediaType: descriptor.MediaType,
+		Size:      descriptor.Size,
+	}
+	if descriptor.MediaType.IsIndex() {
+		index, err := descriptor.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		if inspection.Index, err = index.IndexManifest(); err != nil {
+			return nil, err
+		}
+		return inspection, nil
+	}
+
+	img, err := descriptor.Image()
+	if err != nil {
+		return nil, err
+	}
+	if inspection.Manifest, err = img.Manifest(); err != nil {
+		return nil, err
+	}
+	if inspection.Config, err = img.ConfigFile(); err != nil {
+		return nil, err
+	}
+	return inspection, nil
+}
@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := mutate.Config(testImage(t, writeTestTar(t, testEntry{name: "/opt/app.txt", content: "app"})),
+		v1.Config{Cmd: []string{"app"}, User: "1000"})
+	assert.NoError(t, err)
+	img = mutate.Annotations(img, map[string]string{"org.opencontainers.image.version": "1.0"}).(v1.Image)
+	image := host + "/app:1.0"
+	ref, err := name.ParseReference(image)
+	assert.NoError(t, err)
+	assert.NoError(t, remote.Write(ref, img))
+
+	inspection, err := Inspect(context.Background(), image, Options{PullInsecure: true})
+	assert.NoError(t, err)
+	digest, err := img.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest.String(), inspection.Digest)
+	assert.Nil(t, inspection.Index)
+	if assert.NotNil(t, inspection.Manifest) && assert.NotNil(t, inspection.Config) {
+		assert.Len(t, inspection.Manifest.Layers, 1)
+		assert.Equal(t, "1.0", inspection.Manifest.Annotations["org.opencontainers.image.version"])
+		assert.Equal(t, []string{"app"}, inspection.Config.Config.Cmd)
+		assert.Equal(t, "1000", inspection.Config.Config.User)
+	}
+
+	index := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), mutate.IndexAddendum{
+		Add:        img,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}},
+	})
+	indexRef, err := name.ParseReference(host + "/app:multi")
+	assert.NoError(t, err)
+	assert.NoError(t, remote.WriteIndex(indexRef, index))
+
+	inspection, err = Inspect(context.Background(), indexRef.String(), Options{PullInsecure: true})
+	assert.NoError(t, err)
+	assert.Equal(t, types.OCIImageIndex, inspection.MediaType)
+	assert.Nil(t, inspection.Manifest)
+	if assert.NotNil(t, inspection.Index) && assert.Len(t, inspection.Index.Manifests, 1) {
+		assert.Equal(t, digest, inspection.Index.Manifests[0].Digest)
+		assert.Equal(t, "linux/arm64", inspection.Index.Manifests[0].Platform.String())
+	}
+
+	_, err = Inspect(context.Background(), host+"/missing:1.0", Options{PullInsecure: true})
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package builder
+
+import (
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// LayerSourcePrefix identifies pre-built layer tar files (optionally compressed) that are appended as they are,
+// e.g. layer:///path/to/layer.tar.gz,media-type=application/vnd.oci.image.layer.v1.tar+gzip,annotation=key=value.
+// The options are separated by commas, so commas aren't allowed in the path nor in the options.
+const LayerSourcePrefix = "layer://"
+
+func getLayerSpec(spec string) (layerPath string, mediaType types.MediaType, annotations map[string]string, err error) {
+	parts := strings.Split(strings.TrimPrefix(spec, LayerSourcePrefix), ",")
+	layerPath = parts[0]
+	if layerPath == "" {
+		return "", "", nil, errors.New("wrong layer format for " + spec + " (expected \"layer://path[,media-type=type][,annotation=key=value]\")")
+	}
+	for _, option := range parts[1:] {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return "", "", nil, errors.New("wrong layer option " + option + " for " + spec + " (expected \"key=value\", commas aren't allowed in layer paths)")
+		}
+		switch kv[0] {
+		case "media-type":
+			mediaType = types.MediaType(kv[1])
+		case "annotation":
+			akv := strings.SplitN(kv[1], "=", 2)
+			if len(akv) != 2 {
+				return "", "", nil, errors.New("wrong layer annotation " + kv[1] + " for " + spec + " (expected \"key=value\")")
+			}
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[akv[0]] = akv[1]
+		default:
+			return "", "", nil, errors.New("unknown layer option " + kv[0] + " for " + spec)
+		}
+	}
+	return layerPath, mediaType, annotations, nil
+}
+
+// rawLayer loads a pre-built layer tar file, detecting whether it's compressed
+func rawLayer(layerPath string, mediaType types.MediaType, annotations map[string]string) (mutate.Addendum, error) {
+	var opts []tarball.LayerOption
+	if mediaType != "" {
+		opts = append(opts, tarball.WithMediaType(mediaType))
+	}
+	addendum, err := tarLayer(layerPath, opts...)
+	if err != nil {
+		return addendum, err
+	}
+	addendum.Annotations = annotations
+	return addendum, nil
+}
+
+// tarLayer loads a tar file as a layer to be appended to the image
+func tarLayer(tarFile string, opts ...tarball.LayerOption) (mutate.Addendum, error) {
+	layer, err := tarball.LayerFromFile(tarFile, opts...)
+	if err != nil {
+		return mutate.Addendum{}, errors.Errorf("reading tar %q: %v", tarFile, err)
+	}
+	return mutate.Addendum{Layer: layer}, nil
+}
+
+// compressLayer loads a tar file as a layer, tracing its compression
+func compressLayer(options Options, tarFile string) (mutate.Addendum, error) {
+	_, span := startSpan(options, "compress")
+	addendum, err := tarLayer(tarFile)
+	if err == nil {
+		var digest v1.Hash
+		var size int64
+		if digest, err = addendum.Layer.Digest(); err == nil {
+			size, err = addendum.Layer.Size()
+		}
+		span.SetAttributes(attribute.String("digest", digest.String()), attribute.Int64("bytes", size))
+	}
+	endSpan(span, err)
+	return addendum, err
+}
+
+// appendLayers appends the layers to the base image, adding the annotations to the last one
+func appendLayers(base v1.Image, annotations map[string]string, additions ...mutate.Addendum) (v1.Image, error) {
+	if len(annotations) > 0 && len(additions) > 0 {
+		last := &additions[len(additions)-1]
+		merged := make(map[string]string, len(annotations)+len(last.Annotations))
+		for k, v := range annotations {
+			merged[k] = v
+		}
+		for k, v := range last.Annotations {
+			merged[k] = v
+		}
+		last.Annotations = merged
+	}
+
+	return mutate.Append(base, additions...)
+}
@@ -0,0 +1,64 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayerSpec(t *testing.T) {
+	layerPath, mediaType, annotations, err := getLayerSpec("layer:///path/to/layer.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, "/path/to/layer.tar.gz", layerPath)
+	assert.Equal(t, types.MediaType(""), mediaType)
+	assert.Nil(t, annotations)
+
+	layerPath, mediaType, annotations, err = getLayerSpec("layer://C:\\layers\\layer.tar,media-type=application/vnd.oci.image.layer.v1.tar,annotation=key=a=b")
+	assert.NoError(t, err)
+	assert.Equal(t, "C:\\layers\\layer.tar", layerPath)
+	assert.Equal(t, types.OCIUncompressedLayer, mediaType)
+	assert.Equal(t, map[string]string{"key": "a=b"}, annotations)
+
+	for _, spec := range []string{
+		"layer://",
+		"layer:///path/to/layer.tar,media-type",
+		"layer:///path/to/layer.tar,annotation=key",
+		"layer:///path/to/layer.tar,unknown=value",
+		"layer:///path/to/a,b.tar",
+	} {
+		_, _, _, err = getLayerSpec(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRawLayer(t *testing.T) {
+	layerFile := writeTestArchive(t, true,
+		&tar.Header{Name: "app/app.jar", Typeflag: tar.TypeReg, Mode: 0o644, Size: 3},
+	)
+	content, err := os.ReadFile(layerFile)
+	assert.NoError(t, err)
+	digest, _, err := v1.SHA256(bytes.NewReader(content))
+	assert.NoError(t, err)
+
+	addendum, err := rawLayer(layerFile, types.OCILayer, map[string]string{"layer": "raw"})
+	assert.NoError(t, err)
+
+	// Compressed layers are appended as they are
+	layerDigest, err := addendum.Layer.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest, layerDigest)
+
+	img, err := appendLayers(empty.Image, map[string]string{"image": "spectrum"}, addendum)
+	assert.NoError(t, err)
+	manifest, err := img.Manifest()
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Layers, 1)
+	assert.Equal(t, types.OCILayer, manifest.Layers[0].MediaType)
+	assert.Equal(t, map[string]string{"layer": "raw", "image": "spectrum"}, manifest.Layers[0].Annotations)
+}
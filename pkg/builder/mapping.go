@@ -0,0 +1,320 @@
+package builder
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/pkg/errors"
+)
+
+// Mapping describes some content to be added to the image as a new layer
+type Mapping struct {
+	// Source is a local file, directory or glob pattern, "-" to read a tar stream from the standard input,
+	// or one of the image://, archive:// and layer:// sources
+	Source string
+	// Destination is the path of the content in the image. It's not used by layer:// sources
+	Destination string
+	// UID, if set, overrides the owner user of the copied files
+	UID *int
+	// GID, if set, overrides the owner group of the copied files
+	GID *int
+	// Mode, if set, overrides the permissions of the copied files
+	Mode *fs.FileMode
+	// Exclude lists the patterns of the files not to be copied. They are matched against both the path
+	// relative to the destination and the name of the files
+	Exclude []string
+	// Recursive, if set, overrides the recursive option for this mapping
+	Recursive *bool
+	// FS, if set, is the file system the source is read from instead of the host one. The source is then
+	// a slash separated path or glob pattern relative to its root, e.g. "static" or "dist/*.js"
+	FS fs.FS
+}
+
+// ParseMapping parses a mapping in the "local:remote" format, or in one of the image://, archive:// and layer:// formats
+func ParseMapping(spec string) (Mapping, error) {
+	return parseMapping(spec, runtime.GOOS)
+}
+
+func parseMapping(spec string, os string) (Mapping, error) {
+	switch {
+	case strings.HasPrefix(spec, LayerSourcePrefix):
+		return Mapping{Source: spec}, nil
+	case strings.HasPrefix(spec, ImageSourcePrefix):
+		idx := strings.Index(spec, "!")
+		paths := strings.Split(spec[idx+1:], ":")
+		if idx < 0 || len(paths) != 2 {
+			return Mapping{}, errors.New("wrong image format for " + spec + " (expected \"image://image!/path:remote\")")
+		}
+		return Mapping{Source: spec[:idx+1] + paths[0], Destination: paths[1]}, nil
+	case strings.HasPrefix(spec, ArchiveSourcePrefix):
+		localPath, targetPath, err := getPaths(strings.TrimPrefix(spec, ArchiveSourcePrefix), os)
+		if err != nil {
+			return Mapping{}, err
+		}
+		return Mapping{Source: ArchiveSourcePrefix + localPath, Destination: targetPath}, nil
+	default:
+		localPath, targetPath, err := getPaths(spec, os)
+		if err != nil {
+			return Mapping{}, err
+		}
+		return Mapping{Source: localPath, Destination: targetPath}, nil
+	}
+}
+
+// ParseCopyMapping parses a mapping in the key=value format, e.g.
+// src=./dist,dst=/app,chown=185:0,chmod=0644,exclude=*.map,recursive=true
+func ParseCopyMapping(spec string) (Mapping, error) {
+	mapping := Mapping{}
+	for _, option := range strings.Split(spec, ",") {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return Mapping{}, errors.New("wrong copy option " + option + " for " + spec + " (expected \"key=value\")")
+		}
+		switch kv[0] {
+		case "src":
+			mapping.Source = kv[1]
+		case "dst":
+			mapping.Destination = kv[1]
+		case "chown":
+			uid, gid, err := ParseOwner(kv[1])
+			if err != nil {
+				return Mapping{}, errors.Wrapf(err, "wrong chown option for %s", spec)
+			}
+			mapping.UID = &uid
+			mapping.GID = &gid
+		case "chmod":
+			mode, err := strconv.ParseUint(kv[1], 8, 32)
+			if err != nil {
+				return Mapping{}, errors.Wrapf(err, "wrong chmod option for %s", spec)
+			}
+			fileMode := fs.FileMode(mode)
+			mapping.Mode = &fileMode
+		case "exclude":
+			mapping.Exclude = append(mapping.Exclude, kv[1])
+		case "recursive":
+			recursive, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return Mapping{}, errors.Wrapf(err, "wrong recursive option for %s", spec)
+			}
+			mapping.Recursive = &recursive
+		default:
+			return Mapping{}, errors.New("unknown copy option " + kv[0] + " for " + spec)
+		}
+	}
+	return mapping, mapping.validate()
+}
+
+// ParseOwner parses a numeric owner in the uid[:gid] format. The group defaults to the user id.
+func ParseOwner(owner string) (uid int, gid int, err error) {
+	parts := strings.SplitN(owner, ":", 2)
+	if uid, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, errors.New("expected a numeric owner in the uid[:gid] format, got " + owner)
+	}
+	gid = uid
+	if len(parts) == 2 {
+		if gid, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, errors.New("expected a numeric owner in the uid[:gid] format, got " + owner)
+		}
+	}
+	return uid, gid, nil
+}
+
+func (m Mapping) validate() error {
+	if m.Source == "" {
+		return errors.New("missing source for mapping")
+	}
+	if m.FS == nil && strings.HasPrefix(m.Source, LayerSourcePrefix) {
+		if m.transforms() {
+			return errors.New("ownership, mode and exclusions are not supported for layer " + m.Source)
+		}
+		return nil
+	}
+	if m.Destination == "" {
+		return errors.New("missing destination for mapping " + m.Source)
+	}
+	for _, pattern := range m.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "wrong exclude pattern %s for mapping %s", pattern, m.Source)
+		}
+	}
+	return nil
+}
+
+// transforms checks whether the mapping alters the packaged files
+func (m Mapping) transforms() bool {
+	return m.UID != nil || m.GID != nil || m.Mode != nil || len(m.Exclude) > 0
+}
+
+// excluded checks whether the path, relative to the destination, matches any exclude pattern
+func (m Mapping) excluded(rel string) bool {
+	for _, pattern := range m.Exclude {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// packageMapping creates the layer described by the mapping, returning the temporary tar file that
+// backs it, if any
+func packageMapping(mapping Mapping, options Options) (mutate.Addendum, string, error) {
+	if err := mapping.validate(); err != nil {
+		return mutate.Addendum{}, "", err
+	}
+
+	if mapping.FS == nil && strings.HasPrefix(mapping.Source, LayerSourcePrefix) {
+		layerPath, mediaType, annotations, err := getLayerSpec(mapping.Source)
+		if err != nil {
+			return mutate.Addendum{}, "", err
+		}
+		options.logger().Info("Adding layer", "phase", "package", "path", layerPath)
+		addendum, err := rawLayer(layerPath, mediaType, annotations)
+		return addendum, "", err
+	}
+
+	tarFile, err := packageTar(mapping, options)
+	if err != nil {
+		return mutate.Addendum{}, tarFile, err
+	}
+	if mapping.transforms() {
+		transformed, err := transformPackage(tarFile, mapping)
+		os.Remove(tarFile)
+		tarFile = transformed
+		if err != nil {
+			return mutate.Addendum{}, tarFile, errors.Wrapf(err, "cannot apply options of mapping %s", mapping.Source)
+		}
+	}
+	addendum, err := compressLayer(options, tarFile)
+	return addendum, tarFile, err
+}
+
+// packageTar packages the content described by the mapping into a layer tar file
+func packageTar(mapping Mapping, options Options) (string, error) {
+	recursive := options.Recursive
+	if mapping.Recursive != nil {
+		recursive = *mapping.Recursive
+	}
+
+	switch {
+	case mapping.FS != nil:
+		tarFile, err := copyFSPackage(mapping.FS, mapping.Source, mapping.Destination, recursive)
+		if err != nil {
+			return tarFile, errors.Wrapf(err, "cannot package %s from file system as tar file", mapping.Source)
+		}
+		return tarFile, nil
+	case strings.HasPrefix(mapping.Source, ImageSourcePrefix):
+		image, sourcePath, err := getImageSource(mapping.Source)
+		if err != nil {
+			return "", err
+		}
+		options.logger().Info("Copying from image", "phase", "package", "image", image, "path", sourcePath, "insecure", options.PullInsecure)
+		tarFile, err := imagePackage(image, sourcePath, mapping.Destination, options)
+		if err != nil {
+			return tarFile, errors.Wrapf(err, "cannot package %s from image %s as tar file", sourcePath, image)
+		}
+		return tarFile, nil
+	case strings.HasPrefix(mapping.Source, ArchiveSourcePrefix):
+		localPath := strings.TrimPrefix(mapping.Source, ArchiveSourcePrefix)
+		tarFile, err := archivePackage(localPath, mapping.Destination)
+		if err != nil {
+			return tarFile, errors.Wrapf(err, "cannot extract archive %s as tar file", localPath)
+		}
+		return tarFile, nil
+	case mapping.Source == StdinSource:
+		if options.Stdin == nil {
+			return "", errors.New("no standard input available for " + mapping.Destination)
+		}
+		options.logger().Info("Reading tar stream from standard input", "phase", "package", "destination", mapping.Destination)
+		tarFile, err := streamPackage(options.Stdin, mapping.Destination)
+		if err != nil {
+			return tarFile, errors.Wrap(err, "cannot read tar stream from standard input")
+		}
+		return tarFile, nil
+	default:
+		tarFile, err := copyPackage(mapping.Source, mapping.Destination, recursive, options.warnings())
+		if err != nil {
+			return tarFile, errors.Wrapf(err, "cannot package dir %s as tar file", mapping.Source)
+		}
+		return tarFile, nil
+	}
+}
+
+// transformPackage applies the ownership, mode and exclusions of the mapping to the layer tar file,
+// writing the result into a new layer tar file
+func transformPackage(tarFile string, mapping Mapping) (file string, err error) {
+	source, err := os.Open(tarFile)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	writer := tar.NewWriter(layerFile)
+	defer writer.Close()
+
+	destination := path.Clean("/" + mapping.Destination)
+	excludedDirs := make(map[string]bool)
+	reader := tar.NewReader(source)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return layerFile.Name(), err
+		}
+
+		entry := path.Clean("/" + header.Name)
+		if excludedDirs[path.Dir(entry)] || mapping.excluded(relativeEntry(entry, destination)) {
+			// Exclusions also apply to the content of the excluded directories
+			excludedDirs[entry] = true
+			continue
+		}
+
+		if mapping.UID != nil {
+			header.Uid = *mapping.UID
+			header.Uname = ""
+		}
+		if mapping.GID != nil {
+			header.Gid = *mapping.GID
+			header.Gname = ""
+		}
+		if mapping.Mode != nil && header.Typeflag != tar.TypeSymlink {
+			header.Mode = int64(mapping.Mode.Perm())
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			return layerFile.Name(), err
+		}
+		if _, err := io.Copy(writer, reader); err != nil {
+			return layerFile.Name(), err
+		}
+	}
+
+	return layerFile.Name(), nil
+}
+
+func relativeEntry(entry, destination string) string {
+	if entry == destination {
+		return path.Base(entry)
+	}
+	if rel := strings.TrimPrefix(entry, strings.TrimSuffix(destination, "/")+"/"); rel != entry {
+		return rel
+	}
+	return strings.TrimPrefix(entry, "/")
+}
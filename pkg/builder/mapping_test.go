@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		spec     string
+		os       string
+		expected Mapping
+		err      bool
+	}{
+		{spec: "./dist:/app", os: "linux", expected: Mapping{Source: "./dist", Destination: "/app"}},
+		{spec: "C:\\dist:/app", os: "windows", expected: Mapping{Source: "C:\\dist", Destination: "/app"}},
+		{spec: "C:\\dist:/app", os: "linux", err: true},
+		{spec: "./dist", os: "linux", err: true},
+		{spec: "-:/app", os: "linux", expected: Mapping{Source: "-", Destination: "/app"}},
+		{spec: "archive://./dist.zip:/app", os: "linux", expected: Mapping{Source: "archive://./dist.zip", Destination: "/app"}},
+		{spec: "archive://C:\\dist.zip:/app", os: "windows", expected: Mapping{Source: "archive://C:\\dist.zip", Destination: "/app"}},
+		{spec: "image://localhost:5000/tools:1.0!/usr/bin/helper:/usr/local/bin", os: "linux", expected: Mapping{Source: "image://localhost:5000/tools:1.0!/usr/bin/helper", Destination: "/usr/local/bin"}},
+		{spec: "layer:///layers/deps.tar.gz,annotation=key=value", os: "linux", expected: Mapping{Source: "layer:///layers/deps.tar.gz,annotation=key=value"}},
+		{spec: "layer:/app", os: "linux", expected: Mapping{Source: "layer", Destination: "/app"}},
+	}
+
+	for _, test := range tests {
+		mapping, err := parseMapping(test.spec, test.os)
+		if test.err {
+			assert.Error(t, err, test.spec)
+			continue
+		}
+		assert.NoError(t, err, test.spec)
+		assert.Equal(t, test.expected, mapping, test.spec)
+	}
+}
+
+func TestParseCopyMapping(t *testing.T) {
+	mapping, err := ParseCopyMapping("src=./dist,dst=/app,chown=185:0,chmod=0644,exclude=*.map,exclude=tmp,recursive=true")
+	assert.NoError(t, err)
+	assert.Equal(t, "./dist", mapping.Source)
+	assert.Equal(t, "/app", mapping.Destination)
+	assert.Equal(t, 185, *mapping.UID)
+	assert.Equal(t, 0, *mapping.GID)
+	assert.Equal(t, fs.FileMode(0o644), *mapping.Mode)
+	assert.Equal(t, []string{"*.map", "tmp"}, mapping.Exclude)
+	assert.True(t, *mapping.Recursive)
+
+	mapping, err = ParseCopyMapping("src=C:\\dist,dst=/app,chown=1001")
+	assert.NoError(t, err)
+	assert.Equal(t, "C:\\dist", mapping.Source)
+	assert.Equal(t, 1001, *mapping.UID)
+	assert.Equal(t, 1001, *mapping.GID)
+	assert.Nil(t, mapping.Mode)
+	assert.Nil(t, mapping.Recursive)
+
+	for _, spec := range []string{
+		"dst=/app",
+		"src=./dist",
+		"src=./dist,dst=/app,chown=jboss",
+		"src=./dist,dst=/app,chmod=rw",
+		"src=./dist,dst=/app,recursive=maybe",
+		"src=./dist,dst=/app,exclude=[",
+		"src=./dist,dst=/app,unknown=value",
+		"src=./dist,dst",
+		"src=layer:///layers/deps.tar,chmod=0644",
+	} {
+		_, err = ParseCopyMapping(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestTransformPackage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-mapping-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	for _, name := range []string{"index.html", "js/app.js", "js/app.js.map", "tmp/cache"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0o600))
+	}
+
+	uid, gid, mode, recursive := 185, 0, fs.FileMode(0o644), true
+	_, tarFile, err := packageMapping(Mapping{
+		Source:      tmpDir,
+		Destination: "/app",
+		UID:         &uid,
+		GID:         &gid,
+		Mode:        &mode,
+		Exclude:     []string{"*.map", "tmp"},
+		Recursive:   &recursive,
+	}, Options{})
+	defer os.Remove(tarFile)
+	assert.NoError(t, err)
+
+	headers := readTestHeaders(t, tarFile)
+	names := make([]string, 0, len(headers))
+	for name, header := range headers {
+		names = append(names, name)
+		assert.Equal(t, 185, header.Uid, name)
+		assert.Equal(t, 0, header.Gid, name)
+		assert.Equal(t, int64(0o644), header.Mode, name)
+	}
+	assert.ElementsMatch(t, []string{"/app", "/app/index.html", "/app/js", "/app/js/app.js"}, names)
+}
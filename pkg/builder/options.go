@@ -1,6 +1,12 @@
 package builder
 
-import "io"
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 type Options struct {
 	PullInsecure    bool
@@ -16,4 +22,83 @@ type Options struct {
 	Jobs            int
 	ClearEntrypoint bool
 	RunAs           string
+	Squash          bool
+	SquashAdded     bool
+	Remove          []string
+	Files           []File
+	Mappings        []Mapping
+	Stdin           io.Reader
+	Sinks           map[string]Sink
+	DaemonHost      string
+	Events          Events
+
+	// Entrypoint, if not nil, replaces the entrypoint of the image. An empty entrypoint clears it
+	Entrypoint []string
+	// Cmd, if not nil, replaces the default arguments of the entrypoint. An empty command clears them
+	Cmd []string
+	// WorkingDir, if set, replaces the working directory of the image
+	WorkingDir string
+	// Env lists environment variables in the KEY=value format, replacing the ones of the image with the same key
+	Env []string
+	// Labels are added to the labels of the image configuration, replacing the ones with the same key
+	Labels map[string]string
+	// ExposedPorts lists ports to expose in the port[/protocol] format, the protocol defaulting to tcp
+	ExposedPorts []string
+
+	// DryRun, if set, builds the image and describes it in the plan of the result, without writing it to the target
+	DryRun bool
+	// Report, if set, compares the image to its base image in the report of the result
+	Report bool
+	// LayerCache, if set, reuses the layers of the mappings whose sources didn't change since a previous build
+	LayerCache *LayerCache
+	// Logger, if set, receives the structured logs of the build, the warnings being logged at the warn level.
+	// Otherwise they're written as text to Stdout and warnings to Stderr
+	Logger *slog.Logger
+	// TracerProvider, if set, is used to trace the build phases instead of the global OpenTelemetry provider
+	TracerProvider trace.TracerProvider
+
+	ctx        context.Context
+	result     *BuildResult
+	bases      *sharedBases
+	stepLogger *slog.Logger
+	warnLogger *slog.Logger
+}
+
+// discardLevel is above any log level, to skip computing values that would only be logged
+const discardLevel = slog.Level(100)
+
+// withLoggers returns the options with the loggers of a single build, so that concurrent builds don't share them
+func (o Options) withLoggers() Options {
+	o.stepLogger = o.logger()
+	o.warnLogger = o.warnings()
+	return o
+}
+
+// logger returns the logger of the build steps
+func (o Options) logger() *slog.Logger {
+	if o.stepLogger != nil {
+		return o.stepLogger
+	}
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return newTextLogger(o.Stdout)
+}
+
+// warnings returns the logger of the build warnings
+func (o Options) warnings() *slog.Logger {
+	if o.warnLogger != nil {
+		return o.warnLogger
+	}
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return newTextLogger(o.Stderr)
+}
+
+func newTextLogger(out io.Writer) *slog.Logger {
+	if out == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: discardLevel}))
+	}
+	return slog.New(slog.NewTextHandler(out, nil))
 }
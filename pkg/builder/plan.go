@@ -0,0 +1,165 @@
+package builder
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Plan describes what a dry run would have written to the target
+type Plan struct {
+	// BaseDigest is the digest the base image resolved to, empty for scratch
+	BaseDigest string `json:"baseDigest,omitempty"`
+	// Target is the reference the image would have been written to
+	Target string `json:"target"`
+	// MediaType is the media type of the manifest of the image
+	MediaType types.MediaType `json:"mediaType"`
+	// ManifestSize is the size of the manifest of the image
+	ManifestSize int64 `json:"manifestSize"`
+	// ConfigDigest is the digest of the configuration of the image
+	ConfigDigest string `json:"configDigest"`
+	// Layers lists all the layers of the image, in order
+	Layers []PlannedLayer `json:"layers"`
+	// ConfigChanges lists the changes to the configuration of the base image
+	ConfigChanges []ConfigChange `json:"configChanges,omitempty"`
+}
+
+// PlannedLayer describes a layer of the image of a dry run
+type PlannedLayer struct {
+	// Source is the source the layer was packaged from, or "base" for the layers of the base image
+	Source    string          `json:"source"`
+	Digest    string          `json:"digest"`
+	Size      int64           `json:"size"`
+	MediaType types.MediaType `json:"mediaType"`
+	// Exists tells whether the layer already exists in the target repository, nil if unknown, e.g. when the target
+	// isn't a registry or the registry couldn't be queried
+	Exists *bool `json:"exists,omitempty"`
+}
+
+// BaseSource is the source of the layers of the base image in the plans
+const BaseSource = "base"
+
+// plan describes the image the build would write to the target, without writing it
+func plan(options Options, sink Sink, ref string, base v1.Image, img v1.Image) (*Plan, error) {
+	p := &Plan{Target: options.Target}
+	var err error
+	if p.BaseDigest, err = baseDigest(options, base); err != nil {
+		return nil, err
+	}
+	if p.MediaType, err = img.MediaType(); err != nil {
+		return nil, err
+	}
+	if p.ManifestSize, err = img.Size(); err != nil {
+		return nil, err
+	}
+	configDigest, err := img.ConfigName()
+	if err != nil {
+		return nil, err
+	}
+	p.ConfigDigest = configDigest.String()
+
+	fromBase, err := layerDigests(base)
+	if err != nil {
+		return nil, err
+	}
+	sources := options.result.layerSources()
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]v1.Hash, 0, len(layers))
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return nil, err
+		}
+		mediaType, err := layer.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		source := sources[digest.String()]
+		if fromBase[digest] {
+			source = BaseSource
+		}
+		p.Layers = append(p.Layers, PlannedLayer{Source: source, Digest: digest.String(), Size: size, MediaType: mediaType})
+		digests = append(digests, digest)
+	}
+
+	if _, ok := sink.(RegistrySink); ok {
+		existing, err := existingBlobs(options, ref, digests)
+		if err != nil {
+			options.warnings().Warn("Cannot check the existing layers", "phase", "plan", "image", ref, "error", err)
+		} else {
+			for i := range p.Layers {
+				exists := existing[digests[i]]
+				p.Layers[i].Exists = &exists
+			}
+		}
+	}
+
+	baseConfig, err := base.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	p.ConfigChanges = configChanges(baseConfig.Config, config.Config)
+	return p, nil
+}
+
+// existingBlobs checks which blobs already exist in the repository of the target
+func existingBlobs(options Options, target string, digests []v1.Hash) (map[v1.Hash]bool, error) {
+	tag, err := name.NewTag(target, makeNameOptions(options.PushInsecure)...)
+	if err != nil {
+		return nil, err
+	}
+	repo := tag.Context()
+	var keychain authn.Keychain = authn.DefaultKeychain
+	if options.PushConfigDir != "" {
+		keychain = NewDirKeyChain(options.PushConfigDir)
+	}
+	auth, err := keychain.Resolve(repo)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := transport.NewWithContext(options.context(), repo.Registry, auth, tracingTransport(options), []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: tr}
+
+	existing := make(map[v1.Hash]bool, len(digests))
+	for _, digest := range digests {
+		url := fmt.Sprintf("%s://%s/v2/%s/blobs/%s", repo.Registry.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), digest)
+		req, err := http.NewRequestWithContext(options.context(), http.MethodHead, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			existing[digest] = true
+		case http.StatusNotFound:
+			existing[digest] = false
+		default:
+			return nil, fmt.Errorf("unexpected status %s checking blob %s", resp.Status, digest)
+		}
+	}
+	return existing, nil
+}
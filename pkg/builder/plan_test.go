@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	var writes int32
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			atomic.AddInt32(&writes, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	base := host + "/app:base"
+	ref, err := name.ParseReference(base)
+	assert.NoError(t, err)
+	baseImage := testImage(t, writeTestTar(t, testEntry{name: "/opt/base.txt", content: "base"}))
+	assert.NoError(t, remote.Write(ref, baseImage))
+	baseDigest, err := baseImage.Digest()
+	assert.NoError(t, err)
+	writes = 0
+
+	tmpDir, err := os.MkdirTemp("", "spectrum-plan-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	target := host + "/app:1.0"
+	result, err := BuildContext(context.Background(), Options{
+		Base:         base,
+		Target:       target,
+		PullInsecure: true,
+		PushInsecure: true,
+		DryRun:       true,
+		Cmd:          []string{"java", "-jar", "/deployments/app.jar"},
+		Labels:       map[string]string{"version": "1.0"},
+		Mappings:     []Mapping{{Source: tmpDir, Destination: "/deployments/"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&writes))
+	targetRef, err := name.ParseReference(target)
+	assert.NoError(t, err)
+	_, err = remote.Head(targetRef)
+	assert.Error(t, err)
+
+	plan := result.Plan
+	if !assert.NotNil(t, plan) {
+		return
+	}
+	assert.Equal(t, target, plan.Target)
+	assert.Equal(t, baseDigest.String(), plan.BaseDigest)
+	assert.NotEmpty(t, plan.ConfigDigest)
+	assert.Positive(t, plan.ManifestSize)
+	if assert.Len(t, plan.Layers, 2) {
+		assert.Equal(t, BaseSource, plan.Layers[0].Source)
+		assert.Equal(t, tmpDir, plan.Layers[1].Source)
+		assert.Equal(t, result.Layers[0].Digest, plan.Layers[1].Digest)
+		if assert.NotNil(t, plan.Layers[0].Exists) && assert.NotNil(t, plan.Layers[1].Exists) {
+			assert.True(t, *plan.Layers[0].Exists)
+			assert.False(t, *plan.Layers[1].Exists)
+		}
+	}
+	assert.Equal(t, []ConfigChange{
+		{Field: "Cmd", Change: "added", Image: "[java -jar /deployments/app.jar]"},
+		{Field: "Labels", Key: "version", Change: "added", Image: "1.0"},
+	}, plan.ConfigChanges)
+}
+
+func TestDryRunLocalTarget(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-plan-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	layout := filepath.Join(tmpDir, "layout")
+	result, err := BuildContext(context.Background(), Options{
+		Target:   OCILayoutScheme + ":" + layout,
+		DryRun:   true,
+		Mappings: []Mapping{{Source: filepath.Join(tmpDir, "app.jar"), Destination: "/deployments/"}},
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Plan) && assert.Len(t, result.Plan.Layers, 1) {
+		assert.Empty(t, result.Plan.BaseDigest)
+		assert.Nil(t, result.Plan.Layers[0].Exists)
+	}
+	_, err = os.Stat(layout)
+	assert.True(t, os.IsNotExist(err))
+}
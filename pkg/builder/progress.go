@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	// PullOperation identifies layers downloaded from a registry
+	PullOperation = "pull"
+	// PushOperation identifies layers uploaded to a registry
+	PushOperation = "push"
+)
+
+// LayerProgress is the progress of a layer being transferred from or to a registry
+type LayerProgress struct {
+	// Operation is either PullOperation or PushOperation
+	Operation string `json:"operation"`
+	// Digest is the digest of the compressed layer
+	Digest v1.Hash `json:"digest"`
+	// Complete is the number of bytes transferred so far
+	Complete int64 `json:"complete"`
+	// Total is the size of the compressed layer
+	Total int64 `json:"total"`
+}
+
+// progressImage reports the progress of the layers read from the image
+type progressImage struct {
+	v1.Image
+	operation string
+	events    LayerProgressEvents
+	stats     *transferStats
+}
+
+// withProgress reports the progress of the layers to the events, if they implement LayerProgressEvents, and
+// records it in the stats, if set
+func withProgress(img v1.Image, operation string, events Events, stats *transferStats) v1.Image {
+	progressEvents, _ := events.(LayerProgressEvents)
+	return &progressImage{Image: img, operation: operation, events: progressEvents, stats: stats}
+}
+
+func (i *progressImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]v1.Layer, 0, len(layers))
+	for _, layer := range layers {
+		wrapped = append(wrapped, i.wrap(layer))
+	}
+	return wrapped, nil
+}
+
+func (i *progressImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return i.wrap(layer), nil
+}
+
+func (i *progressImage) LayerByDiffID(h v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDiffID(h)
+	if err != nil {
+		return nil, err
+	}
+	return i.wrap(layer), nil
+}
+
+// wrap reports the progress of reading the compressed layer, which the uncompressed content is also read from.
+// Mountable layers are kept mountable, so that they can still be mounted across repositories when pushed.
+func (i *progressImage) wrap(layer v1.Layer) v1.Layer {
+	mountable, isMountable := layer.(*remote.MountableLayer)
+	if isMountable {
+		layer = mountable.Layer
+	}
+	wrapped, _ := partial.CompressedToLayer(&progressLayer{Layer: layer, operation: i.operation, events: i.events, stats: i.stats})
+	if isMountable {
+		return &remote.MountableLayer{Layer: wrapped, Reference: mountable.Reference}
+	}
+	return wrapped
+}
+
+type progressLayer struct {
+	v1.Layer
+	operation string
+	events    LayerProgressEvents
+	stats     *transferStats
+}
+
+func (l *progressLayer) Descriptor() (*v1.Descriptor, error) {
+	return partial.Descriptor(l.Layer)
+}
+
+func (l *progressLayer) Compressed() (io.ReadCloser, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := l.Size()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	return &progressReader{
+		ReadCloser: rc,
+		events:     l.events,
+		stats:      l.stats,
+		progress:   LayerProgress{Operation: l.operation, Digest: digest, Total: size},
+	}, nil
+}
+
+type progressReader struct {
+	io.ReadCloser
+	events   LayerProgressEvents
+	stats    *transferStats
+	progress LayerProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.progress.Complete += int64(n)
+		if r.stats != nil {
+			r.stats.add(r.progress.Digest, int64(n))
+		}
+		if r.events != nil {
+			r.events.OnLayerProgress(r.progress)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,287 @@
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Report describes what a build changed compared to its base image
+type Report struct {
+	// Base is the base image of the build, empty for scratch
+	Base string `json:"base,omitempty"`
+	// BaseDigest is the digest the base image resolved to, empty for scratch
+	BaseDigest string `json:"baseDigest,omitempty"`
+	// Target is the target of the image
+	Target string `json:"target"`
+	// Digest is the digest of the image
+	Digest string `json:"digest"`
+	// ConfigChanges lists the changes to the configuration of the base image
+	ConfigChanges []ConfigChange `json:"configChanges,omitempty"`
+	// Layers lists the layers added on top of the base image, in order
+	Layers []ReportLayer `json:"layers"`
+}
+
+// ReportLayer describes a layer added on top of the base image
+type ReportLayer struct {
+	// Source is the source the layer was packaged from
+	Source string `json:"source"`
+	Digest string `json:"digest"`
+	// Size is the size of the compressed layer
+	Size int64 `json:"size"`
+	// Files lists the entries of the layer, in order
+	Files []ReportFile `json:"files"`
+}
+
+// ReportFile describes an entry of an added layer
+type ReportFile struct {
+	// Path is the absolute path of the entry in the image
+	Path string `json:"path"`
+	// Type is one of file, dir, symlink, hardlink or other, or removed for the paths of the base image hidden
+	// by whiteouts. Removed directories whose content only is hidden keep a trailing slash.
+	Type string `json:"type"`
+	// Size is the size of the regular files
+	Size int64 `json:"size,omitempty"`
+	// Mode is the permissions of the entry, in octal
+	Mode string `json:"mode,omitempty"`
+	// Link is the target of the links
+	Link string `json:"link,omitempty"`
+}
+
+// ConfigChange describes a change to a field of the configuration of the base image, or to a key of the Env, Labels
+// and ExposedPorts fields
+type ConfigChange struct {
+	Field string `json:"field"`
+	// Key is the name of the environment variable, the label or the exposed port, if any
+	Key string `json:"key,omitempty"`
+	// Change is one of added, removed or changed
+	Change string `json:"change"`
+	// Base is the value in the base image, if any
+	Base string `json:"base,omitempty"`
+	// Image is the value in the built image, if any
+	Image string `json:"image,omitempty"`
+}
+
+// report compares the image to its base image, listing the configuration changes and the files of the added layers
+func report(options Options, base v1.Image, img v1.Image) (*Report, error) {
+	r := &Report{Target: options.Target}
+	var err error
+	if r.BaseDigest, err = baseDigest(options, base); err != nil {
+		return nil, err
+	}
+	if r.BaseDigest != "" {
+		r.Base = options.Base
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	r.Digest = digest.String()
+
+	baseConfig, err := base.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	r.ConfigChanges = configChanges(baseConfig.Config, config.Config)
+
+	fromBase, err := layerDigests(base)
+	if err != nil {
+		return nil, err
+	}
+	sources := options.result.layerSources()
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	r.Layers = []ReportLayer{}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if fromBase[digest] {
+			continue
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return nil, err
+		}
+		source := sources[digest.String()]
+		if source == "" && options.Squash {
+			source = "squash"
+		}
+		files, err := layerFiles(layer)
+		if err != nil {
+			return nil, err
+		}
+		r.Layers = append(r.Layers, ReportLayer{Source: source, Digest: digest.String(), Size: size, Files: files})
+	}
+	return r, nil
+}
+
+// baseDigest returns the digest of the base image, or an empty string for scratch
+func baseDigest(options Options, base v1.Image) (string, error) {
+	if options.Base == "" || options.Base == "scratch" {
+		return "", nil
+	}
+	digest, err := base.Digest()
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+// layerDigests returns the set of the digests of the layers of the image
+func layerDigests(img v1.Image) (map[v1.Hash]bool, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[v1.Hash]bool, len(layers))
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		digests[digest] = true
+	}
+	return digests, nil
+}
+
+// layerFiles lists the entries of the layer, turning the whiteouts into the paths they remove
+func layerFiles(layer v1.Layer) ([]ReportFile, error) {
+	reader, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	files := []ReportFile{}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		entry := path.Clean("/" + header.Name)
+		dir, name := path.Split(entry)
+		switch {
+		case name == whiteoutOpaque:
+			files = append(files, ReportFile{Path: dir, Type: "removed"})
+			continue
+		case strings.HasPrefix(name, whiteoutPrefix):
+			files = append(files, ReportFile{Path: dir + strings.TrimPrefix(name, whiteoutPrefix), Type: "removed"})
+			continue
+		}
+
+		file := ReportFile{Path: entry, Mode: fmt.Sprintf("%04o", header.Mode&0o7777)}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			file.Type = "file"
+			file.Size = header.Size
+		case tar.TypeDir:
+			file.Type = "dir"
+		case tar.TypeSymlink:
+			file.Type = "symlink"
+			file.Link = header.Linkname
+			file.Mode = ""
+		case tar.TypeLink:
+			file.Type = "hardlink"
+			file.Link = path.Clean("/" + header.Linkname)
+		default:
+			file.Type = "other"
+		}
+		files = append(files, file)
+	}
+}
+
+// configChanges compares the fields of the configurations changed by the builds, and the keys of the environment
+// variables, labels and exposed ports one by one
+func configChanges(base v1.Config, config v1.Config) []ConfigChange {
+	var changes []ConfigChange
+	compare := func(field string, baseValue, value string) {
+		if baseValue != value {
+			changes = append(changes, ConfigChange{Field: field, Change: changeOf(baseValue != "", value != ""), Base: baseValue, Image: value})
+		}
+	}
+	compare("Entrypoint", formatList(base.Entrypoint), formatList(config.Entrypoint))
+	compare("Cmd", formatList(base.Cmd), formatList(config.Cmd))
+	compare("User", base.User, config.User)
+	compare("WorkingDir", base.WorkingDir, config.WorkingDir)
+	changes = append(changes, keyChanges("Env", envMap(base.Env), envMap(config.Env))...)
+	changes = append(changes, keyChanges("Labels", base.Labels, config.Labels)...)
+	ports := func(exposed map[string]struct{}) map[string]string {
+		values := make(map[string]string, len(exposed))
+		for port := range exposed {
+			values[port] = ""
+		}
+		return values
+	}
+	changes = append(changes, keyChanges("ExposedPorts", ports(base.ExposedPorts), ports(config.ExposedPorts))...)
+	return changes
+}
+
+// keyChanges compares the values of the field key by key, in the order of the keys
+func keyChanges(field string, base map[string]string, values map[string]string) []ConfigChange {
+	keys := make([]string, 0, len(base)+len(values))
+	for key := range base {
+		keys = append(keys, key)
+	}
+	for key := range values {
+		if _, inBase := base[key]; !inBase {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []ConfigChange
+	for _, key := range keys {
+		baseValue, inBase := base[key]
+		value, inImage := values[key]
+		if inBase && inImage && baseValue == value {
+			continue
+		}
+		changes = append(changes, ConfigChange{Field: field, Key: key, Change: changeOf(inBase, inImage), Base: baseValue, Image: value})
+	}
+	return changes
+}
+
+func changeOf(inBase bool, inImage bool) string {
+	switch {
+	case !inBase:
+		return "added"
+	case !inImage:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// envMap returns the values of the environment variables in the KEY=value format, the last one of a key winning
+func envMap(env []string) map[string]string {
+	values := make(map[string]string, len(env))
+	for _, variable := range env {
+		key, value, _ := strings.Cut(variable, "=")
+		values[key] = value
+	}
+	return values
+}
+
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
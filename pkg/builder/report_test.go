@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	base := host + "/base:1.0"
+	ref, err := name.ParseReference(base)
+	assert.NoError(t, err)
+	baseImage, err := mutate.Config(testImage(t, writeTestTar(t,
+		testEntry{name: "/opt/", dir: true},
+		testEntry{name: "/opt/old.txt", content: "old"},
+	)), v1.Config{Cmd: []string{"sh"}, Env: []string{"PATH=/bin"}})
+	assert.NoError(t, err)
+	assert.NoError(t, remote.Write(ref, baseImage))
+	baseDigest, err := baseImage.Digest()
+	assert.NoError(t, err)
+
+	tmpDir, err := os.MkdirTemp("", "spectrum-report-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	result, err := BuildContext(context.Background(), Options{
+		Base:         base,
+		Target:       host + "/app:1.0",
+		PullInsecure: true,
+		PushInsecure: true,
+		Report:       true,
+		Cmd:          []string{"java", "-jar", "/deployments/app.jar"},
+		Env:          []string{"JAVA_OPTS=-Xmx1g"},
+		ExposedPorts: []string{"8080"},
+		Remove:       []string{"/opt/old.txt"},
+		Mappings:     []Mapping{{Source: filepath.Join(tmpDir, "app.jar"), Destination: "/deployments/"}},
+	})
+	assert.NoError(t, err)
+
+	report := result.Report
+	if !assert.NotNil(t, report) {
+		return
+	}
+	assert.Equal(t, base, report.Base)
+	assert.Equal(t, baseDigest.String(), report.BaseDigest)
+	assert.Equal(t, result.Digest, report.Digest)
+	assert.Equal(t, []ConfigChange{
+		{Field: "Cmd", Change: "changed", Base: "[sh]", Image: "[java -jar /deployments/app.jar]"},
+		{Field: "Env", Key: "JAVA_OPTS", Change: "added", Image: "-Xmx1g"},
+		{Field: "ExposedPorts", Key: "8080/tcp", Change: "added"},
+	}, report.ConfigChanges)
+
+	var added, removed []ReportFile
+	for _, layer := range report.Layers {
+		assert.NotEmpty(t, layer.Digest)
+		for _, file := range layer.Files {
+			if file.Type == "removed" {
+				removed = append(removed, file)
+			} else if file.Type == "file" {
+				added = append(added, file)
+			}
+		}
+	}
+	assert.Len(t, report.Layers, 2)
+	assert.Equal(t, []ReportFile{{Path: "/opt/old.txt", Type: "removed"}}, removed)
+	assert.Equal(t, []ReportFile{{Path: "/deployments/app.jar", Type: "file", Size: 3, Mode: "0644"}}, added)
+}
+
+func TestConfigChanges(t *testing.T) {
+	base := v1.Config{
+		User:         "root",
+		Env:          []string{"PATH=/bin", "LANG=C", "DEBUG=1"},
+		Labels:       map[string]string{"version": "1.0", "vendor": "acme"},
+		ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+	}
+	config := v1.Config{
+		Env:          []string{"PATH=/app/bin:/bin", "LANG=C", "JAVA_OPTS="},
+		Labels:       map[string]string{"version": "2.0", "vendor": "acme"},
+		ExposedPorts: map[string]struct{}{"443/tcp": {}, "8080/tcp": {}},
+	}
+	assert.Equal(t, []ConfigChange{
+		{Field: "User", Change: "removed", Base: "root"},
+		{Field: "Env", Key: "DEBUG", Change: "removed", Base: "1"},
+		{Field: "Env", Key: "JAVA_OPTS", Change: "added"},
+		{Field: "Env", Key: "PATH", Change: "changed", Base: "/bin", Image: "/app/bin:/bin"},
+		{Field: "Labels", Key: "version", Change: "changed", Base: "1.0", Image: "2.0"},
+		{Field: "ExposedPorts", Key: "80/tcp", Change: "removed"},
+		{Field: "ExposedPorts", Key: "8080/tcp", Change: "added"},
+	}, configChanges(base, config))
+}
@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// BuildResult describes the outcome of a build
+type BuildResult struct {
+	// Base is the base image of the build
+	Base string `json:"base"`
+	// Target is the target the image has been written to
+	Target string `json:"target"`
+	// Registry is the registry the image has been pushed to, empty if it has been written to another sink
+	Registry string `json:"registry,omitempty"`
+	// Digest is the digest of the built image
+	Digest string `json:"digest"`
+	// Layers lists the layers packaged by the build, in order
+	Layers []LayerResult `json:"layers"`
+	// BlobsUploaded is the number of layers uploaded to the registry
+	BlobsUploaded int `json:"blobsUploaded"`
+	// BlobsSkipped is the number of layers already existing in the registry, or mounted from another repository
+	BlobsSkipped int `json:"blobsSkipped"`
+	// BytesUploaded is the size of the layers uploaded to the registry
+	BytesUploaded int64 `json:"bytesUploaded"`
+	// BytesSkipped is the size of the layers that didn't need to be uploaded
+	BytesSkipped int64 `json:"bytesSkipped"`
+	// PullDuration is the time spent pulling the base image
+	PullDuration time.Duration `json:"pullDuration"`
+	// PushDuration is the time spent writing the image to the target
+	PushDuration time.Duration `json:"pushDuration"`
+	// Duration is the time spent by the whole build
+	Duration time.Duration `json:"duration"`
+	// Plan describes what would have been written to the target, only set by dry runs
+	Plan *Plan `json:"plan,omitempty"`
+	// Report describes what the build changed compared to the base image, only set when requested
+	Report *Report `json:"report,omitempty"`
+}
+
+// LayerResult describes a layer packaged by a build
+type LayerResult struct {
+	// Source is the source the layer was packaged from
+	Source string `json:"source"`
+	// Digest is the digest of the compressed layer
+	Digest string `json:"digest"`
+	// Size is the size of the compressed layer
+	Size int64 `json:"size"`
+	// Reused tells whether the layer was reused from a previous build, its sources being unchanged
+	Reused bool `json:"reused,omitempty"`
+}
+
+func (r *BuildResult) addLayer(source string, digest v1.Hash, size int64, reused bool) {
+	if r != nil {
+		r.Layers = append(r.Layers, LayerResult{Source: source, Digest: digest.String(), Size: size, Reused: reused})
+	}
+}
+
+// layerSources maps the digests of the packaged layers to their sources
+func (r *BuildResult) layerSources() map[string]string {
+	sources := make(map[string]string)
+	if r != nil {
+		for _, layer := range r.Layers {
+			sources[layer.Digest] = layer.Source
+		}
+	}
+	return sources
+}
+
+// transferStats records the bytes read from each layer, to tell the uploaded layers from the skipped ones
+type transferStats struct {
+	mu   sync.Mutex
+	read map[v1.Hash]int64
+}
+
+func (s *transferStats) add(digest v1.Hash, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.read == nil {
+		s.read = make(map[v1.Hash]int64)
+	}
+	s.read[digest] += n
+}
+
+// record adds the uploaded and skipped layers of the image to the result
+func (s *transferStats) record(img v1.Image, result *BuildResult) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[v1.Hash]bool)
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		if seen[digest] {
+			continue
+		}
+		seen[digest] = true
+		if read, ok := s.read[digest]; ok {
+			result.BlobsUploaded++
+			result.BytesUploaded += read
+			continue
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return err
+		}
+		result.BlobsSkipped++
+		result.BytesSkipped += size
+	}
+	return nil
+}
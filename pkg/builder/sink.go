@@ -0,0 +1,179 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/daemon"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+)
+
+const (
+	// OCILayoutScheme identifies targets written to an OCI image layout directory, e.g. oci:./build/layout:1.0
+	OCILayoutScheme = "oci"
+	// DaemonScheme identifies targets loaded into a local Docker or Podman daemon, e.g. daemon:myorg/myapp:dev
+	DaemonScheme = "daemon"
+	// DockerArchiveScheme identifies targets written to a tarball loadable with docker load,
+	// e.g. docker-archive:./build/app.tar:myorg/myapp:1.0
+	DockerArchiveScheme = "docker-archive"
+)
+
+// Sink is a destination the built image can be written to
+type Sink interface {
+	// Write writes the image to the given reference, stripped of the sink scheme
+	Write(ref string, img v1.Image, options Options) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ref string, img v1.Image, options Options) error
+
+func (f SinkFunc) Write(ref string, img v1.Image, options Options) error {
+	return f(ref, img, options)
+}
+
+// RegistrySink pushes images to a container registry. It's used for targets without a scheme.
+type RegistrySink struct{}
+
+func (RegistrySink) Write(ref string, img v1.Image, options Options) error {
+	options.Target = ref
+	return Push(img, options)
+}
+
+// OCILayoutSink writes images into an OCI image layout directory, creating it if needed.
+// The reference is the path of the directory, optionally followed by the tag to be stored as ref name.
+type OCILayoutSink struct{}
+
+func (OCILayoutSink) Write(ref string, img v1.Image, options Options) error {
+	dir, tag := splitSinkRef(ref)
+	if dir == "" {
+		return errors.New("missing OCI layout path in " + ref)
+	}
+
+	layoutPath, err := layout.FromPath(dir)
+	if os.IsNotExist(errors.Cause(err)) {
+		layoutPath, err = layout.Write(dir, empty.Index)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot open OCI layout %s", dir)
+	}
+
+	var layoutOptions []layout.Option
+	if tag != "" {
+		layoutOptions = append(layoutOptions, layout.WithAnnotations(map[string]string{
+			"org.opencontainers.image.ref.name": tag,
+		}))
+	}
+	return layoutPath.AppendImage(img, layoutOptions...)
+}
+
+// DockerArchiveSink writes images into a tarball that can be loaded with docker load.
+// The reference is the path of the tarball followed by the image reference to be loaded as.
+type DockerArchiveSink struct{}
+
+func (DockerArchiveSink) Write(ref string, img v1.Image, options Options) error {
+	file, imageRef := splitSinkRef(ref)
+	if file == "" || imageRef == "" {
+		return errors.New("wrong docker archive format for " + ref + " (expected \"path:image\")")
+	}
+	tag, err := name.NewTag(imageRef)
+	if err != nil {
+		return fmt.Errorf("parsing tag %q: %v", imageRef, err)
+	}
+	return tarball.WriteToFile(file, tag, img)
+}
+
+// DaemonSink loads images into a local Docker or Podman daemon, tagging them with the reference. The daemon is
+// reached through the client, if set, or through the host of the options, defaulting to the DOCKER_HOST environment.
+type DaemonSink struct {
+	Client daemon.Client
+}
+
+func (s DaemonSink) Write(ref string, img v1.Image, options Options) error {
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		return fmt.Errorf("parsing tag %q: %v", ref, err)
+	}
+
+	daemonClient := s.Client
+	if daemonClient == nil {
+		clientOptions := []client.Opt{client.FromEnv}
+		if options.DaemonHost != "" {
+			clientOptions = append(clientOptions, client.WithHost(options.DaemonHost))
+		}
+		newClient, err := client.NewClientWithOpts(clientOptions...)
+		if err != nil {
+			return errors.Wrap(err, "cannot create daemon client")
+		}
+		defer newClient.Close()
+		daemonClient = newClient
+	}
+
+	_, err = daemon.Write(tag, img, daemon.WithClient(daemonClient))
+	return err
+}
+
+// MemorySink keeps the written images in memory, mainly for testing purposes
+type MemorySink struct {
+	mu     sync.Mutex
+	images map[string]v1.Image
+}
+
+func (s *MemorySink) Write(ref string, img v1.Image, options Options) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.images == nil {
+		s.images = make(map[string]v1.Image)
+	}
+	s.images[ref] = img
+	return nil
+}
+
+// Image returns the image written to the given reference, if any
+func (s *MemorySink) Image(ref string) (v1.Image, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img, ok := s.images[ref]
+	return img, ok
+}
+
+var builtinSinks = map[string]Sink{
+	OCILayoutScheme:     OCILayoutSink{},
+	DaemonScheme:        DaemonSink{},
+	DockerArchiveScheme: DockerArchiveSink{},
+}
+
+// resolveSink returns the sink the target is written to, and the target stripped of its scheme. The sinks of the
+// options take precedence over the built-in ones, while targets without a known scheme are pushed to a registry.
+func resolveSink(target string, options Options) (Sink, string) {
+	if idx := strings.Index(target, ":"); idx > 0 {
+		scheme, ref := target[:idx], target[idx+1:]
+		if sink, ok := options.Sinks[scheme]; ok {
+			return sink, ref
+		}
+		if sink, ok := builtinSinks[scheme]; ok {
+			return sink, ref
+		}
+	}
+	return RegistrySink{}, target
+}
+
+// splitSinkRef splits a reference in the "path:rest" format, taking care of Windows drive letters
+func splitSinkRef(ref string) (string, string) {
+	start := 0
+	if len(ref) > 2 && ref[1] == ':' && (ref[2] == '\\' || ref[2] == '/') {
+		start = 2
+	}
+	idx := strings.Index(ref[start:], ":")
+	if idx < 0 {
+		return ref, ""
+	}
+	return ref[:start+idx], ref[start+idx+1:]
+}
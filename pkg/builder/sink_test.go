@@ -0,0 +1,191 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSink(t *testing.T) {
+	memory := &MemorySink{}
+	options := Options{Sinks: map[string]Sink{"mem": memory}}
+
+	tests := []struct {
+		target string
+		sink   Sink
+		ref    string
+	}{
+		{target: "localhost:5000/myorg/app:1.0", sink: RegistrySink{}, ref: "localhost:5000/myorg/app:1.0"},
+		{target: "myorg/app", sink: RegistrySink{}, ref: "myorg/app"},
+		{target: "oci:./layout:1.0", sink: OCILayoutSink{}, ref: "./layout:1.0"},
+		{target: "docker-archive:app.tar:myorg/app:1.0", sink: DockerArchiveSink{}, ref: "app.tar:myorg/app:1.0"},
+		{target: "daemon:myapp:dev", sink: DaemonSink{}, ref: "myapp:dev"},
+		{target: "mem:app", sink: memory, ref: "app"},
+	}
+
+	for _, test := range tests {
+		sink, ref := resolveSink(test.target, options)
+		assert.Equal(t, test.sink, sink, test.target)
+		assert.Equal(t, test.ref, ref, test.target)
+	}
+}
+
+func TestSplitSinkRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		path string
+		rest string
+	}{
+		{ref: "./layout", path: "./layout"},
+		{ref: "./layout:1.0", path: "./layout", rest: "1.0"},
+		{ref: "app.tar:myorg/app:1.0", path: "app.tar", rest: "myorg/app:1.0"},
+		{ref: "C:\\build\\app.tar:myorg/app", path: "C:\\build\\app.tar", rest: "myorg/app"},
+	}
+
+	for _, test := range tests {
+		path, rest := splitSinkRef(test.ref)
+		assert.Equal(t, test.path, path, test.ref)
+		assert.Equal(t, test.rest, rest, test.ref)
+	}
+}
+
+func TestOCILayoutSink(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-sink-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	img := testImage(t, writeTestTar(t, testEntry{name: "/app/app.jar", content: "app"}))
+	dir := filepath.Join(tmpDir, "layout")
+
+	sink, ref := resolveSink("oci:"+dir+":1.0", Options{})
+	assert.NoError(t, sink.Write(ref, img, Options{}))
+	assert.NoError(t, sink.Write(dir, img, Options{}))
+
+	layoutPath, err := layout.FromPath(dir)
+	assert.NoError(t, err)
+	index, err := layoutPath.ImageIndex()
+	assert.NoError(t, err)
+	manifest, err := index.IndexManifest()
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Manifests, 2)
+	assert.Equal(t, "1.0", manifest.Manifests[0].Annotations["org.opencontainers.image.ref.name"])
+	expected, err := img.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, manifest.Manifests[0].Digest)
+}
+
+func TestDockerArchiveSink(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-sink-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	img := testImage(t, writeTestTar(t, testEntry{name: "/app/app.jar", content: "app"}))
+	file := filepath.Join(tmpDir, "app.tar")
+
+	sink, ref := resolveSink("docker-archive:"+file+":myorg/app:1.0", Options{})
+	assert.NoError(t, sink.Write(ref, img, Options{}))
+	assert.Error(t, sink.Write(file, img, Options{}))
+
+	tag, err := name.NewTag("myorg/app:1.0")
+	assert.NoError(t, err)
+	loaded, err := tarball.ImageFromPath(file, &tag)
+	assert.NoError(t, err)
+	expected, err := img.Digest()
+	assert.NoError(t, err)
+	digest, err := loaded.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, digest)
+}
+
+type fakeDaemonClient struct {
+	loaded []byte
+	tags   []string
+}
+
+func (c *fakeDaemonClient) NegotiateAPIVersion(ctx context.Context) {}
+
+func (c *fakeDaemonClient) ImageSave(ctx context.Context, refs []string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeDaemonClient) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	loaded, err := io.ReadAll(input)
+	if err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	c.loaded = loaded
+	return types.ImageLoadResponse{Body: io.NopCloser(strings.NewReader(`{"stream":"Loaded image"}`))}, nil
+}
+
+func (c *fakeDaemonClient) ImageTag(ctx context.Context, source, target string) error {
+	c.tags = append(c.tags, target)
+	return nil
+}
+
+func (c *fakeDaemonClient) ImageInspectWithRaw(ctx context.Context, id string) (types.ImageInspect, []byte, error) {
+	if c.loaded == nil {
+		return types.ImageInspect{}, nil, errors.New("no such image " + id)
+	}
+	return types.ImageInspect{ID: id, RepoTags: []string{"myapp:dev"}}, nil, nil
+}
+
+func (c *fakeDaemonClient) ImageHistory(ctx context.Context, id string) ([]image.HistoryResponseItem, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestDaemonSink(t *testing.T) {
+	img := testImage(t, writeTestTar(t, testEntry{name: "/app/app.jar", content: "app"}))
+	daemonClient := &fakeDaemonClient{}
+	options := Options{Sinks: map[string]Sink{DaemonScheme: DaemonSink{Client: daemonClient}}}
+
+	sink, ref := resolveSink("daemon:myapp:dev", options)
+	assert.NoError(t, sink.Write(ref, img, options))
+	assert.NotEmpty(t, daemonClient.loaded)
+
+	tag, err := name.NewTag("myapp:dev")
+	assert.NoError(t, err)
+	loaded, err := tarball.Image(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(daemonClient.loaded)), nil
+	}, &tag)
+	assert.NoError(t, err)
+	expected, err := img.Digest()
+	assert.NoError(t, err)
+	digest, err := loaded.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, digest)
+
+	// Images already known to the daemon are tagged without being loaded again
+	assert.NoError(t, sink.Write("myapp:latest", img, options))
+	assert.Equal(t, []string{"myapp:latest"}, daemonClient.tags)
+	assert.Error(t, sink.Write("myapp:@dev", img, options))
+}
+
+func TestBuildMemorySink(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-sink-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	sink := &MemorySink{}
+	digest, err := Build(Options{
+		Target: "mem:myorg/app",
+		Sinks:  map[string]Sink{"mem": sink},
+	}, tmpDir+":/deployments")
+	assert.NoError(t, err)
+
+	img, ok := sink.Image("myorg/app")
+	assert.True(t, ok)
+	imgDigest, err := img.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest, imgDigest.String())
+}
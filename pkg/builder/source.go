@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"archive/tar"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Source is a file or a directory to be packaged, read from a file system
+type Source struct {
+	// FS is the file system containing the files, e.g. an embed.FS, an fstest.MapFS or a zip.Reader
+	FS fs.FS
+	// Path is the slash separated path of the file or directory within the file system, "." being its root
+	Path string
+
+	host bool
+	// warnings receives the warnings about the files of host sources, if set
+	warnings *slog.Logger
+}
+
+// HostSource returns the source of a file or directory of the host file system. Files of host sources
+// are owned by the current user, while files of other sources are owned by root.
+func HostSource(name string) (Source, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return Source{}, err
+	}
+	dir, base := filepath.Split(abs)
+	if base == "" {
+		return Source{FS: os.DirFS(abs), Path: ".", host: true}, nil
+	}
+	return Source{FS: os.DirFS(dir), Path: base, host: true}, nil
+}
+
+// join returns the source of a file contained in the source directory
+func (s Source) join(name string) Source {
+	return Source{FS: s.FS, Path: path.Join(s.Path, name), host: s.host, warnings: s.warnings}
+}
+
+// stat returns the information of the source file, following symbolic links
+func (s Source) stat() (fs.FileInfo, error) {
+	return fs.Stat(s.FS, s.Path)
+}
+
+func (s Source) header(name string, fi fs.FileInfo) *tar.Header {
+	if s.host {
+		return prepareHeader(path.Dir(name), name, fi, s.warnings)
+	}
+	return &tar.Header{
+		Name:    name,
+		Size:    fi.Size(),
+		Mode:    int64(fi.Mode().Perm()),
+		ModTime: fi.ModTime(),
+	}
+}
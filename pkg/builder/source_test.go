@@ -0,0 +1,74 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyFSPackage(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static/index.html":  {Data: []byte("index"), Mode: 0o644},
+		"static/js/app.js":   {Data: []byte("app"), Mode: 0o755},
+		"static/css/app.css": {Data: []byte("css"), Mode: 0o644},
+		"VERSION":            {Data: []byte("1.0"), Mode: 0o644},
+	}
+
+	tests := []struct {
+		name       string
+		targetPath string
+		recursive  bool
+		expected   map[string]string
+		err        bool
+	}{
+		{name: "static", targetPath: "/app", recursive: true, expected: map[string]string{
+			"/app/": "", "/app/index.html": "index", "/app/js/": "", "/app/js/app.js": "app", "/app/css/": "", "/app/css/app.css": "css",
+		}},
+		{name: "static", targetPath: "/app", expected: map[string]string{"/app/index.html": "index"}},
+		{name: ".", targetPath: "/app", expected: map[string]string{"/app/VERSION": "1.0"}},
+		{name: "VERSION", targetPath: "/app/version.txt", expected: map[string]string{"/app/version.txt": "1.0"}},
+		{name: "static/*/*", targetPath: "/app/", expected: map[string]string{"/app/app.js": "app", "/app/app.css": "css"}},
+		{name: "static/*/*", targetPath: "/app", err: true},
+		{name: "static/*.txt", targetPath: "/app/", err: true},
+		{name: "/static", targetPath: "/app", err: true},
+		{name: "missing", targetPath: "/app", err: true},
+	}
+
+	for _, test := range tests {
+		tarFile, err := copyFSPackage(fsys, test.name, test.targetPath, test.recursive)
+		if tarFile != "" {
+			defer os.Remove(tarFile)
+		}
+		if test.err {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+
+		file, err := os.Open(tarFile)
+		assert.NoError(t, err)
+		defer file.Close()
+		assert.Equal(t, test.expected, readTestTar(t, file), test.name)
+	}
+}
+
+func TestHostSource(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-source-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	source, err := HostSource(filepath.Join(tmpDir, "app.jar"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app.jar", source.Path)
+	fi, err := source.stat()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), fi.Size())
+
+	source, err = HostSource(string(filepath.Separator))
+	assert.NoError(t, err)
+	assert.Equal(t, ".", source.Path)
+}
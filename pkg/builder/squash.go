@@ -0,0 +1,181 @@
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// squashImage flattens all the layers of the image into a single layer, stored in a temporary tar file.
+// The history and the diff IDs of the resulting image are rewritten to reflect the single layer.
+func squashImage(img v1.Image, annotations map[string]string) (v1.Image, string, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, "", err
+	}
+
+	tarFile, err := flattenLayers(layers, false)
+	if err != nil {
+		return nil, tarFile, err
+	}
+	layer, err := tarball.LayerFromFile(tarFile)
+	if err != nil {
+		return nil, tarFile, fmt.Errorf("reading tar %q: %v", tarFile, err)
+	}
+
+	confFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, tarFile, err
+	}
+	confFile = confFile.DeepCopy()
+	confFile.RootFS.DiffIDs = nil
+	confFile.History = nil
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, tarFile, err
+	}
+	squashed := mutate.MediaType(empty.Image, manifest.MediaType)
+	squashed = mutate.ConfigMediaType(squashed, manifest.Config.MediaType)
+	squashed, err = mutate.ConfigFile(squashed, confFile)
+	if err != nil {
+		return nil, tarFile, err
+	}
+
+	squashed, err = mutate.Append(squashed, mutate.Addendum{
+		Layer:       layer,
+		Annotations: annotations,
+		History: v1.History{
+			Created:   confFile.Created,
+			CreatedBy: "spectrum build --squash",
+			Comment:   fmt.Sprintf("squashed %d layers", len(layers)),
+		},
+	})
+	return squashed, tarFile, err
+}
+
+// squashAdditions merges the added layers into a single tar file. Files in later layers override the ones
+// in earlier layers, while whiteout entries are preserved so that they still apply to the underlying base image.
+func squashAdditions(additions []mutate.Addendum) (file string, err error) {
+	layers := make([]v1.Layer, 0, len(additions))
+	for _, addendum := range additions {
+		layers = append(layers, addendum.Layer)
+	}
+	return flattenLayers(layers, true)
+}
+
+// flattenLayers writes the merged content of the layers into a temporary tar file. Whiteout entries are
+// applied to the lower layers and, if requested, kept in the result.
+func flattenLayers(layers []v1.Layer, whiteouts bool) (file string, err error) {
+	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	content := extractLayers(layers, whiteouts)
+	defer content.Close()
+	if _, err := io.Copy(layerFile, content); err != nil {
+		return layerFile.Name(), err
+	}
+	return layerFile.Name(), nil
+}
+
+// extractLayers returns a tar stream with the merged content of the layers. Unlike mutate.Extract, entry
+// names are compared regardless of leading slashes and opaque directories are supported.
+func extractLayers(layers []v1.Layer, whiteouts bool) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		writer := tar.NewWriter(pw)
+		err := extractLayersTo(layers, writer, whiteouts)
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func extractLayersTo(layers []v1.Layer, writer *tar.Writer, whiteouts bool) error {
+	// Layers are processed from the topmost one, so that the first occurrence of a path wins.
+	// A true value means that the entry hides any path below it as well (files and whiteouts).
+	seen := make(map[string]bool)
+	for i := len(layers) - 1; i >= 0; i-- {
+		opaque, err := flattenLayer(layers[i], writer, seen, whiteouts)
+		if err != nil {
+			return err
+		}
+		// Opaque directories only hide the content of the layers below
+		for _, dir := range opaque {
+			seen[path.Join(dir, whiteoutOpaque)] = true
+		}
+	}
+	return nil
+}
+
+func flattenLayer(layer v1.Layer, writer *tar.Writer, seen map[string]bool, whiteouts bool) (opaque []string, err error) {
+	content, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	reader := tar.NewReader(content)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		entry := path.Clean("/" + header.Name)
+		dir, base := path.Split(entry)
+		whiteout := strings.HasPrefix(base, whiteoutPrefix)
+		if whiteout && base != whiteoutOpaque {
+			entry = path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+		}
+
+		if _, ok := seen[entry]; ok || hiddenByParent(seen, entry) {
+			continue
+		}
+		// Opaque markers are recorded once the whole layer has been processed
+		seen[entry] = base != whiteoutOpaque && (whiteout || header.Typeflag != tar.TypeDir)
+		if base == whiteoutOpaque {
+			opaque = append(opaque, dir)
+		}
+		if whiteout && !whiteouts {
+			continue
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(writer, reader); err != nil {
+			return nil, err
+		}
+	}
+
+	return opaque, nil
+}
+
+// hiddenByParent checks whether any parent of the given entry hides it, either because it has been replaced
+// by a file, removed with a whiteout or made opaque in an upper layer.
+func hiddenByParent(seen map[string]bool, entry string) bool {
+	for dir := path.Dir(entry); ; dir = path.Dir(dir) {
+		if seen[dir] || seen[path.Join(dir, whiteoutOpaque)] {
+			return true
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package builder
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntry struct {
+	name     string
+	content  string
+	dir      bool
+	linkname string
+}
+
+func writeTestTar(t *testing.T, entries ...testEntry) string {
+	layerFile, err := os.CreateTemp("", "spectrum-test-*.tar")
+	assert.NoError(t, err)
+	defer layerFile.Close()
+	t.Cleanup(func() { os.Remove(layerFile.Name()) })
+
+	writer := tar.NewWriter(layerFile)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		if entry.dir {
+			header.Mode = 0o755
+			header.Typeflag = tar.TypeDir
+		} else if entry.linkname != "" {
+			header.Mode = 0o777
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.linkname
+		}
+		assert.NoError(t, writer.WriteHeader(header))
+		_, err := writer.Write([]byte(entry.content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	return layerFile.Name()
+}
+
+func readTestTar(t *testing.T, reader io.Reader) map[string]string {
+	content := make(map[string]string)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		content[header.Name] = string(data)
+	}
+	return content
+}
+
+func testImage(t *testing.T, tarFiles ...string) v1.Image {
+	img := empty.Image
+	for _, tarFile := range tarFiles {
+		layer, err := tarball.LayerFromFile(tarFile)
+		assert.NoError(t, err)
+		img, err = mutate.AppendLayers(img, layer)
+		assert.NoError(t, err)
+	}
+	return img
+}
+
+func TestSquashAdditions(t *testing.T) {
+	layer1 := writeTestTar(t,
+		testEntry{name: "/app/", dir: true},
+		testEntry{name: "/app/a.txt", content: "a1"},
+		testEntry{name: "/app/b.txt", content: "b1"},
+		testEntry{name: "/app/lib/", dir: true},
+		testEntry{name: "/app/lib/c.txt", content: "c1"},
+	)
+	layer2 := writeTestTar(t,
+		testEntry{name: "/app/a.txt", content: "a2"},
+		testEntry{name: "/app/.wh.b.txt"},
+		testEntry{name: "/etc/.wh.config"},
+		testEntry{name: "/app/lib/", dir: true},
+		testEntry{name: "/app/lib/.wh..wh..opq"},
+		testEntry{name: "/app/lib/d.txt", content: "d2"},
+	)
+
+	layers, err := testImage(t, layer1, layer2).Layers()
+	assert.NoError(t, err)
+	tarFile, err := squashAdditions([]mutate.Addendum{{Layer: layers[0]}, {Layer: layers[1]}})
+	assert.NoError(t, err)
+	defer os.Remove(tarFile)
+
+	file, err := os.Open(tarFile)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	assert.Equal(t, map[string]string{
+		"/app/":                 "",
+		"/app/a.txt":            "a2",
+		"/app/.wh.b.txt":        "",
+		"/etc/.wh.config":       "",
+		"/app/lib/":             "",
+		"/app/lib/.wh..wh..opq": "",
+		"/app/lib/d.txt":        "d2",
+	}, readTestTar(t, file))
+}
+
+func TestSquashImage(t *testing.T) {
+	img := testImage(t,
+		writeTestTar(t,
+			testEntry{name: "app/a.txt", content: "a1"},
+			testEntry{name: "/app/b.txt", content: "b1"},
+		),
+		writeTestTar(t,
+			testEntry{name: "/app/a.txt", content: "a2"},
+			testEntry{name: "/app/.wh.b.txt"},
+		),
+	)
+
+	squashed, tarFile, err := squashImage(img, map[string]string{"key": "value"})
+	assert.NoError(t, err)
+	defer os.Remove(tarFile)
+
+	layers, err := squashed.Layers()
+	assert.NoError(t, err)
+	assert.Len(t, layers, 1)
+
+	confFile, err := squashed.ConfigFile()
+	assert.NoError(t, err)
+	assert.Len(t, confFile.History, 1)
+	assert.Len(t, confFile.RootFS.DiffIDs, 1)
+	diffID, err := layers[0].DiffID()
+	assert.NoError(t, err)
+	assert.Equal(t, diffID, confFile.RootFS.DiffIDs[0])
+
+	manifest, err := squashed.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, "value", manifest.Layers[0].Annotations["key"])
+
+	content, err := layers[0].Uncompressed()
+	assert.NoError(t, err)
+	defer content.Close()
+	assert.Equal(t, map[string]string{
+		"/app/a.txt": "a2",
+	}, readTestTar(t, content))
+}
@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/container-tools/spectrum/pkg/builder"
+
+func (o Options) tracerProvider() trace.TracerProvider {
+	if o.TracerProvider == nil {
+		return otel.GetTracerProvider()
+	}
+	return o.TracerProvider
+}
+
+func (o Options) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// startSpan starts a span as a child of the span of the options, returning the options carrying the new span
+func startSpan(options Options, name string, attributes ...attribute.KeyValue) (Options, trace.Span) {
+	ctx, span := options.tracerProvider().Tracer(tracerName).Start(options.context(), name, trace.WithAttributes(attributes...))
+	options.ctx = ctx
+	return options, span
+}
+
+// endSpan ends the span, recording the error if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingTransport traces the requests sent to the registries, e.g. the upload of each blob
+func tracingTransport(options Options) http.RoundTripper {
+	return otelhttp.NewTransport(remote.DefaultTransport,
+		otelhttp.WithTracerProvider(options.tracerProvider()),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBuildTracing(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "spectrum-tracing-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	_, err = Build(Options{
+		Target:         strings.TrimPrefix(server.URL, "http://") + "/myapp:1.0",
+		PushInsecure:   true,
+		RunAs:          "185",
+		TracerProvider: provider,
+	}, tmpDir+":/deployments")
+	assert.NoError(t, err)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	var uploads []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if strings.HasPrefix(span.Name(), "PUT /v2/myapp/blobs/") {
+			uploads = append(uploads, span)
+		}
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"build", "pull", "package", "compress", "config", "push"} {
+		assert.Contains(t, spans, name)
+	}
+	build := spans["build"].SpanContext()
+	for _, name := range []string{"pull", "package", "config", "push"} {
+		assert.Equal(t, build.SpanID(), spans[name].Parent().SpanID(), name)
+	}
+	assert.Equal(t, spans["package"].SpanContext().SpanID(), spans["compress"].Parent().SpanID())
+
+	// Layer and config blobs
+	assert.Len(t, uploads, 2)
+	for _, upload := range uploads {
+		assert.Equal(t, spans["push"].SpanContext().TraceID(), upload.SpanContext().TraceID())
+	}
+}
+
+func TestBuildContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := BuildContext(ctx, Options{
+		Target: "mem:app",
+		Sinks:  map[string]Sink{"mem": &MemorySink{}},
+	}, "./testdata:/deployments")
+	assert.ErrorIs(t, err, context.Canceled)
+}
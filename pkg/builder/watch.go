@@ -0,0 +1,147 @@
+package builder
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// DefaultWatchDebounce is the time without changes waited for before rebuilding
+const DefaultWatchDebounce = 300 * time.Millisecond
+
+// Watch builds the image, then builds it again each time the local sources of the mappings and files change, until
+// the context is done. Bursts of changes are debounced, and only the mappings whose sources changed are packaged
+// again. The result of each build is passed to the callback, and a failed build doesn't stop watching.
+func Watch(ctx context.Context, options Options, debounce time.Duration, onBuild func(*BuildResult, error)) error {
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+	roots, err := watchedPaths(options)
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		return errors.New("no local sources to watch")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "cannot watch the sources")
+	}
+	defer watcher.Close()
+	for _, root := range roots {
+		if err := watchTree(watcher, root); err != nil {
+			return errors.Wrapf(err, "cannot watch %s", root)
+		}
+	}
+
+	if options.LayerCache == nil {
+		options.LayerCache = NewLayerCache()
+		defer options.LayerCache.Close()
+	}
+	logger := options.logger()
+	onBuild(BuildContext(ctx, options))
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !watched(roots, event.Name) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				// New directories aren't watched by their parents
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					_ = watchTree(watcher, event.Name)
+				}
+			}
+			timer.Reset(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Warn("Watch error", "phase", "watch", "error", err)
+		case <-timer.C:
+			logger.Info("Sources changed, rebuilding", "phase", "watch")
+			onBuild(BuildContext(ctx, options))
+		}
+	}
+}
+
+// watchedPaths returns the local paths the mappings and the files are packaged from. Glob patterns are watched
+// through the directory containing them.
+func watchedPaths(options Options) ([]string, error) {
+	var paths []string
+	add := func(p string) error {
+		if strings.ContainsAny(p, "*?[") {
+			p = filepath.Dir(p)
+			for strings.ContainsAny(p, "*?[") {
+				p = filepath.Dir(p)
+			}
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, abs)
+		return nil
+	}
+	for _, mapping := range options.Mappings {
+		if mapping.Source == StdinSource {
+			return nil, errors.New("the standard input can't be watched")
+		}
+		if localPath, ok := LocalPath(mapping); ok {
+			if err := add(localPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, file := range options.Files {
+		if file.Source != "" {
+			if err := add(file.Source); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return paths, nil
+}
+
+// watchTree watches the directory and its subdirectories, or the directory containing the file, since files are
+// often replaced rather than written by the editors
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return watcher.Add(filepath.Dir(root))
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return watcher.Add(p)
+	})
+}
+
+// watched tells whether the changed path is within any of the watched paths
+func watched(roots []string, name string) bool {
+	for _, root := range roots {
+		if name == root || strings.HasPrefix(name, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayerCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-cache-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "app"), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "lib"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app", "app.jar"), []byte("app"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "lib", "lib.jar"), []byte("lib"), 0o644))
+
+	cache := NewLayerCache()
+	defer cache.Close()
+	options := Options{
+		Target:     "mem:app",
+		Sinks:      map[string]Sink{"mem": &MemorySink{}},
+		LayerCache: cache,
+		Mappings: []Mapping{
+			{Source: filepath.Join(tmpDir, "lib"), Destination: "/deployments/lib/"},
+			{Source: filepath.Join(tmpDir, "app", "*.jar"), Destination: "/deployments/"},
+		},
+	}
+	first, err := BuildContext(context.Background(), options)
+	assert.NoError(t, err)
+	assert.False(t, first.Layers[0].Reused)
+	assert.False(t, first.Layers[1].Reused)
+
+	later := time.Now().Add(time.Second)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app", "app.jar"), []byte("app v2"), 0o644))
+	assert.NoError(t, os.Chtimes(filepath.Join(tmpDir, "app", "app.jar"), later, later))
+	second, err := BuildContext(context.Background(), options)
+	assert.NoError(t, err)
+	assert.True(t, second.Layers[0].Reused)
+	assert.Equal(t, first.Layers[0].Digest, second.Layers[0].Digest)
+	assert.False(t, second.Layers[1].Reused)
+	assert.NotEqual(t, first.Layers[1].Digest, second.Layers[1].Digest)
+	assert.NotEqual(t, first.Digest, second.Digest)
+
+	// A mapping with other options is a different layer
+	options.Mappings[0].Destination = "/opt/lib/"
+	third, err := BuildContext(context.Background(), options)
+	assert.NoError(t, err)
+	assert.False(t, third.Layers[0].Reused)
+	assert.True(t, third.Layers[1].Reused)
+}
+
+func TestWatch(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-watch-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan *BuildResult, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, Options{
+			Target:   "mem:app",
+			Sinks:    map[string]Sink{"mem": &MemorySink{}},
+			Mappings: []Mapping{{Source: tmpDir, Destination: "/deployments/"}},
+		}, 50*time.Millisecond, func(result *BuildResult, err error) {
+			assert.NoError(t, err)
+			results <- result
+		})
+	}()
+
+	receive := func() *BuildResult {
+		select {
+		case result := <-results:
+			return result
+		case <-time.After(10 * time.Second):
+			t.Fatal("no build")
+			return nil
+		}
+	}
+	first := receive()
+
+	// A burst of changes triggers a single build
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app v"+string(rune('0'+i))), 0o644))
+	}
+	second := receive()
+	assert.NotEqual(t, first.Digest, second.Digest)
+	select {
+	case <-results:
+		t.Fatal("unexpected build")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestWatchedPaths(t *testing.T) {
+	paths, err := watchedPaths(Options{
+		Mappings: []Mapping{
+			{Source: "/src/dist", Destination: "/app"},
+			{Source: "/src/target/*/lib/*.jar", Destination: "/app/lib/"},
+			{Source: "archive:///src/app.tar.gz", Destination: "/app"},
+			{Source: "layer:///src/deps.tar.gz,media-type=application/vnd.oci.image.layer.v1.tar+gzip"},
+			{Source: "image://tools:1.0!/usr/bin/helper", Destination: "/usr/bin"},
+		},
+		Files: []File{{Path: "/etc/app.conf", Source: "/src/app.conf"}, {Path: "/etc/profile", Content: []byte("dev")}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/src/dist", "/src/target", "/src/app.tar.gz", "/src/deps.tar.gz", "/src/app.conf"}, paths)
+
+	_, err = watchedPaths(Options{Mappings: []Mapping{{Source: StdinSource, Destination: "/app"}}})
+	assert.Error(t, err)
+}
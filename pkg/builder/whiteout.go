@@ -0,0 +1,64 @@
+package builder
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// whiteoutPackage creates a layer tar file hiding the given paths of the lower layers. A path ending with a
+// slash is turned into an opaque directory, which keeps the directory but hides all of its content.
+func whiteoutPackage(paths []string) (file string, err error) {
+	layerFile, err := ioutil.TempFile("", "spectrum-layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+
+	writer := tar.NewWriter(layerFile)
+	defer writer.Close()
+
+	for _, p := range paths {
+		name, err := whiteoutName(p)
+		if err != nil {
+			return layerFile.Name(), err
+		}
+		header := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			ModTime:  time.Unix(0, 0),
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return layerFile.Name(), err
+		}
+	}
+
+	return layerFile.Name(), nil
+}
+
+func whiteoutName(p string) (string, error) {
+	if !path.IsAbs(p) {
+		return "", fmt.Errorf("wrong path to remove %q: expected an absolute path", p)
+	}
+	if path.Clean(p) == "/" {
+		// Hiding the root would hide the whole file system of the base image
+		return "", fmt.Errorf("wrong path to remove %q: the root can't be removed", p)
+	}
+	if strings.HasSuffix(p, "/") {
+		return path.Join(p, whiteoutOpaque), nil
+	}
+	dir, base := path.Split(path.Clean(p))
+	if base == "" || base == "." || base == ".." {
+		return "", fmt.Errorf("wrong path to remove %q", p)
+	}
+	return path.Join(dir, whiteoutPrefix+base), nil
+}
@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhiteoutName(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		err      bool
+	}{
+		{path: "/etc/app.conf", expected: "/etc/.wh.app.conf"},
+		{path: "/deployments", expected: "/.wh.deployments"},
+		{path: "/deployments/", expected: "/deployments/.wh..wh..opq"},
+		{path: "/", err: true},
+		{path: "//", err: true},
+		{path: "/deployments/../", err: true},
+		{path: "/.", err: true},
+		{path: "deployments", err: true},
+		{path: "/deployments/..", err: true},
+	}
+
+	for _, test := range tests {
+		name, err := whiteoutName(test.path)
+		if test.err {
+			assert.Error(t, err, test.path)
+			continue
+		}
+		assert.NoError(t, err, test.path)
+		assert.Equal(t, test.expected, name, test.path)
+	}
+}
+
+func TestWhiteoutRemovesFromBase(t *testing.T) {
+	base := []string{
+		writeTestTar(t,
+			testEntry{name: "deployments/", dir: true},
+			testEntry{name: "deployments/sample.jar", content: "sample"},
+			testEntry{name: "deployments/lib/", dir: true},
+			testEntry{name: "deployments/lib/dep.jar", content: "dep"},
+			testEntry{name: "etc/", dir: true},
+			testEntry{name: "etc/app.conf", content: "default"},
+			testEntry{name: "etc/other.conf", content: "other"},
+			testEntry{name: "opt/", dir: true},
+			testEntry{name: "opt/tool/", dir: true},
+			testEntry{name: "opt/tool/bin", content: "tool"},
+		),
+	}
+
+	whiteouts, err := whiteoutPackage([]string{"/deployments/", "/etc/app.conf", "/opt/tool"})
+	defer os.Remove(whiteouts)
+	assert.NoError(t, err)
+	app := writeTestTar(t,
+		testEntry{name: "/deployments/app.jar", content: "app"},
+	)
+
+	layers, err := testImage(t, append(base, whiteouts, app)...).Layers()
+	assert.NoError(t, err)
+	fs, err := flattenLayers(layers, false)
+	defer os.Remove(fs)
+	assert.NoError(t, err)
+
+	file, err := os.Open(fs)
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, map[string]string{
+		"/deployments/app.jar": "app",
+		"deployments/":         "",
+		"etc/":                 "",
+		"etc/other.conf":       "other",
+		"opt/":                 "",
+	}, readTestTar(t, file))
+}
@@ -0,0 +1,436 @@
+// Package buildfile loads the builds described by a spectrum.yaml file
+package buildfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the supported version of the build file
+const Version = "v1"
+
+// File describes a build, or a batch of builds of multiple images. Relative local paths are resolved against the
+// directory of the file.
+type File struct {
+	Version string `yaml:"version"`
+	Build   `yaml:",inline"`
+	// Images, if set, are the images to build instead of the top-level target. They inherit the top-level values:
+	// strings are overridden, flags are enabled, annotations are merged and mappings, files and paths to remove are
+	// added after the top-level ones
+	Images []Build `yaml:"images"`
+}
+
+// Build describes the build of an image
+type Build struct {
+	Base          string            `yaml:"base"`
+	Target        string            `yaml:"target"`
+	PullInsecure  bool              `yaml:"pullInsecure"`
+	PushInsecure  bool              `yaml:"pushInsecure"`
+	PullConfigDir string            `yaml:"pullConfigDir"`
+	PushConfigDir string            `yaml:"pushConfigDir"`
+	DaemonHost    string            `yaml:"daemonHost"`
+	Recursive     bool              `yaml:"recursive"`
+	Squash        bool              `yaml:"squash"`
+	SquashAdded   bool              `yaml:"squashAdded"`
+	Annotations   map[string]string `yaml:"annotations"`
+	Mappings      []Mapping         `yaml:"mappings"`
+	Files         []FileContent     `yaml:"files"`
+	Remove        []string          `yaml:"remove"`
+	Config        Config            `yaml:"config"`
+}
+
+// Mapping describes some content to be added to the image, with the same sources as the command line mappings
+type Mapping struct {
+	Source      string   `yaml:"source"`
+	Destination string   `yaml:"destination"`
+	Chown       string   `yaml:"chown"`
+	Chmod       string   `yaml:"chmod"`
+	Exclude     []string `yaml:"exclude"`
+	Recursive   *bool    `yaml:"recursive"`
+}
+
+// FileContent describes a file to be created in the image, either from a local file or with the given content
+type FileContent struct {
+	Path    string  `yaml:"path"`
+	Mode    string  `yaml:"mode"`
+	Source  string  `yaml:"source"`
+	Content *string `yaml:"content"`
+}
+
+// Config describes the changes to the configuration of the image
+type Config struct {
+	ClearEntrypoint bool   `yaml:"clearEntrypoint"`
+	RunAs           string `yaml:"runAs"`
+}
+
+// FieldError is a validation error of a field of the build file
+type FieldError struct {
+	File  string
+	Line  int
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %v", e.File, e.Line, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Load reads and validates the build file
+func Load(name string) (*File, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(name, data)
+}
+
+// Parse parses and validates the content of the named build file
+func Parse(name string, data []byte) (*File, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s", name)
+	}
+	file := File{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s", name)
+	}
+	if err := file.validate(); err != nil {
+		if fieldErr, ok := err.(*FieldError); ok {
+			fieldErr.File = name
+			fieldErr.Line = line(&root, fieldErr.Field)
+		}
+		return nil, err
+	}
+	return &file, nil
+}
+
+func fieldError(field string, err error) error {
+	return &FieldError{Field: field, Err: err}
+}
+
+// nestedError prefixes the field of the error with its parent field
+func nestedError(parent string, err error) error {
+	if fieldErr, ok := err.(*FieldError); ok {
+		return fieldError(parent+"."+fieldErr.Field, fieldErr.Err)
+	}
+	return err
+}
+
+func (f *File) validate() error {
+	switch f.Version {
+	case Version:
+	case "":
+		return fieldError("version", errors.New("missing version (expected "+Version+")"))
+	default:
+		return fieldError("version", errors.New("unsupported version "+strconv.Quote(f.Version)+" (expected "+Version+")"))
+	}
+	if len(f.Images) > 0 && f.Target != "" {
+		return fieldError("target", errors.New("the top-level target can't be set together with images"))
+	}
+	if err := f.Build.validate(); err != nil {
+		return err
+	}
+
+	targets := make(map[string]bool, len(f.Images))
+	for i, image := range f.Images {
+		field := fmt.Sprintf("images[%d]", i)
+		if image.Target == "" {
+			return fieldError(field+".target", errors.New("missing target"))
+		}
+		if targets[image.Target] {
+			return fieldError(field+".target", errors.New("duplicate target "+image.Target))
+		}
+		targets[image.Target] = true
+		if err := image.validate(); err != nil {
+			return nestedError(field, err)
+		}
+		if merged := f.Build.merge(image); merged.Squash && merged.SquashAdded {
+			return fieldError(field+".squashAdded", errors.New("only one of squash and squashAdded can be specified"))
+		}
+	}
+	return nil
+}
+
+func (b *Build) validate() error {
+	if b.Squash && b.SquashAdded {
+		return fieldError("squashAdded", errors.New("only one of squash and squashAdded can be specified"))
+	}
+	for i, m := range b.Mappings {
+		if _, err := m.mapping(""); err != nil {
+			return nestedError(fmt.Sprintf("mappings[%d]", i), err)
+		}
+	}
+	for i, c := range b.Files {
+		if _, err := c.file(""); err != nil {
+			return nestedError(fmt.Sprintf("files[%d]", i), err)
+		}
+	}
+	for i, p := range b.Remove {
+		if !path.IsAbs(p) {
+			return fieldError(fmt.Sprintf("remove[%d]", i), errors.New("expected an absolute path, got "+p))
+		}
+	}
+	return nil
+}
+
+// merge returns the build of the image, inheriting the values of this build
+func (b Build) merge(image Build) Build {
+	merged := b
+	override := func(value *string, imageValue string) {
+		if imageValue != "" {
+			*value = imageValue
+		}
+	}
+	override(&merged.Base, image.Base)
+	override(&merged.Target, image.Target)
+	override(&merged.PullConfigDir, image.PullConfigDir)
+	override(&merged.PushConfigDir, image.PushConfigDir)
+	override(&merged.DaemonHost, image.DaemonHost)
+	override(&merged.Config.RunAs, image.Config.RunAs)
+	merged.PullInsecure = b.PullInsecure || image.PullInsecure
+	merged.PushInsecure = b.PushInsecure || image.PushInsecure
+	merged.Recursive = b.Recursive || image.Recursive
+	merged.Squash = b.Squash || image.Squash
+	merged.SquashAdded = b.SquashAdded || image.SquashAdded
+	merged.Config.ClearEntrypoint = b.Config.ClearEntrypoint || image.Config.ClearEntrypoint
+
+	if len(b.Annotations) > 0 || len(image.Annotations) > 0 {
+		merged.Annotations = make(map[string]string, len(b.Annotations)+len(image.Annotations))
+		for k, v := range b.Annotations {
+			merged.Annotations[k] = v
+		}
+		for k, v := range image.Annotations {
+			merged.Annotations[k] = v
+		}
+	}
+	merged.Mappings = append(append([]Mapping(nil), b.Mappings...), image.Mappings...)
+	merged.Files = append(append([]FileContent(nil), b.Files...), image.Files...)
+	merged.Remove = append(append([]string(nil), b.Remove...), image.Remove...)
+	return merged
+}
+
+// Options returns the options of the top-level build, resolving the relative local paths against the given
+// directory
+func (f *File) Options(dir string) (builder.Options, error) {
+	return f.Build.options(dir)
+}
+
+// ImageOptions returns the options of the builds of each image, or of the top-level build if there are no images,
+// resolving the relative local paths against the given directory
+func (f *File) ImageOptions(dir string) ([]builder.Options, error) {
+	if len(f.Images) == 0 {
+		options, err := f.Options(dir)
+		return []builder.Options{options}, err
+	}
+	images := make([]builder.Options, 0, len(f.Images))
+	for i, image := range f.Images {
+		options, err := f.Build.merge(image).options(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "wrong image %d", i)
+		}
+		images = append(images, options)
+	}
+	return images, nil
+}
+
+func (b Build) options(dir string) (builder.Options, error) {
+	options := builder.Options{
+		Base:            b.Base,
+		Target:          b.Target,
+		PullInsecure:    b.PullInsecure,
+		PushInsecure:    b.PushInsecure,
+		PullConfigDir:   resolve(dir, b.PullConfigDir),
+		PushConfigDir:   resolve(dir, b.PushConfigDir),
+		DaemonHost:      b.DaemonHost,
+		Recursive:       b.Recursive,
+		Squash:          b.Squash,
+		SquashAdded:     b.SquashAdded,
+		Remove:          b.Remove,
+		ClearEntrypoint: b.Config.ClearEntrypoint,
+		RunAs:           b.Config.RunAs,
+	}
+	if len(b.Annotations) > 0 {
+		options.Annotations = make(map[string]string, len(b.Annotations))
+		for k, v := range b.Annotations {
+			options.Annotations[k] = v
+		}
+	}
+	for i, m := range b.Mappings {
+		mapping, err := m.mapping(dir)
+		if err != nil {
+			return options, errors.Wrapf(err, "wrong mapping %d", i)
+		}
+		options.Mappings = append(options.Mappings, mapping)
+	}
+	for i, c := range b.Files {
+		file, err := c.file(dir)
+		if err != nil {
+			return options, errors.Wrapf(err, "wrong file %d", i)
+		}
+		options.Files = append(options.Files, file)
+	}
+	return options, nil
+}
+
+func (m Mapping) mapping(dir string) (builder.Mapping, error) {
+	if m.Source == "" {
+		return builder.Mapping{}, fieldError("source", errors.New("missing source"))
+	}
+	mapping := builder.Mapping{
+		Source:      resolveSource(dir, m.Source),
+		Destination: m.Destination,
+		Exclude:     m.Exclude,
+		Recursive:   m.Recursive,
+	}
+	layer := strings.HasPrefix(m.Source, builder.LayerSourcePrefix)
+	if m.Destination == "" && !layer {
+		return builder.Mapping{}, fieldError("destination", errors.New("missing destination"))
+	}
+	if strings.HasPrefix(m.Source, builder.ImageSourcePrefix) && !strings.Contains(m.Source, "!/") {
+		return builder.Mapping{}, fieldError("source", errors.New("wrong image source "+m.Source+" (expected \"image://image!/path\")"))
+	}
+	if layer && (m.Chown != "" || m.Chmod != "" || len(m.Exclude) > 0) {
+		return builder.Mapping{}, fieldError("source", errors.New("ownership, mode and exclusions are not supported for layer "+m.Source))
+	}
+	if m.Chown != "" {
+		uid, gid, err := builder.ParseOwner(m.Chown)
+		if err != nil {
+			return builder.Mapping{}, fieldError("chown", err)
+		}
+		mapping.UID = &uid
+		mapping.GID = &gid
+	}
+	if m.Chmod != "" {
+		mode, err := parseMode(m.Chmod)
+		if err != nil {
+			return builder.Mapping{}, fieldError("chmod", err)
+		}
+		mapping.Mode = &mode
+	}
+	for i, pattern := range m.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return builder.Mapping{}, fieldError(fmt.Sprintf("exclude[%d]", i), errors.Wrapf(err, "wrong pattern %s", pattern))
+		}
+	}
+	return mapping, nil
+}
+
+func (c FileContent) file(dir string) (builder.File, error) {
+	if !path.IsAbs(c.Path) || path.Base(c.Path) == "/" {
+		return builder.File{}, fieldError("path", errors.New("expected an absolute file path, got "+strconv.Quote(c.Path)))
+	}
+	file := builder.File{Path: c.Path}
+	switch {
+	case c.Source != "" && c.Content != nil:
+		return builder.File{}, fieldError("content", errors.New("only one of source and content can be specified"))
+	case c.Source != "":
+		file.Source = resolve(dir, c.Source)
+	case c.Content != nil:
+		file.Content = []byte(*c.Content)
+	default:
+		return builder.File{}, fieldError("source", errors.New("one of source and content is required"))
+	}
+	if c.Mode != "" {
+		mode, err := parseMode(c.Mode)
+		if err != nil {
+			return builder.File{}, fieldError("mode", err)
+		}
+		file.Mode = &mode
+	}
+	return file, nil
+}
+
+func parseMode(mode string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o7777 {
+		return 0, errors.New("expected an octal mode, e.g. 0644, got " + strconv.Quote(mode))
+	}
+	return fs.FileMode(m), nil
+}
+
+// resolveSource resolves the local path of the archive://, layer:// and local sources
+func resolveSource(dir string, source string) string {
+	switch {
+	case strings.HasPrefix(source, builder.ImageSourcePrefix) || source == builder.StdinSource:
+		return source
+	case strings.HasPrefix(source, builder.ArchiveSourcePrefix):
+		return builder.ArchiveSourcePrefix + resolve(dir, strings.TrimPrefix(source, builder.ArchiveSourcePrefix))
+	case strings.HasPrefix(source, builder.LayerSourcePrefix):
+		spec := strings.TrimPrefix(source, builder.LayerSourcePrefix)
+		layerPath, options, found := strings.Cut(spec, ",")
+		resolved := builder.LayerSourcePrefix + resolve(dir, layerPath)
+		if found {
+			resolved += "," + options
+		}
+		return resolved
+	default:
+		return resolve(dir, source)
+	}
+}
+
+func resolve(dir string, p string) string {
+	if p == "" || dir == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// line returns the line of the field, e.g. mappings[1].chmod, or of its closest parent found in the document
+func line(root *yaml.Node, field string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	current := node.Line
+	for _, element := range strings.Split(field, ".") {
+		key, index := element, -1
+		if i := strings.Index(element, "["); i >= 0 {
+			key = element[:i]
+			index, _ = strconv.Atoi(strings.TrimSuffix(element[i+1:], "]"))
+		}
+		next := mappingValue(node, key)
+		if next == nil {
+			return current
+		}
+		node, current = next, next.Line
+		if index >= 0 {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return current
+			}
+			node = node.Content[index]
+			current = node.Line
+		}
+	}
+	return current
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package buildfile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/stretchr/testify/assert"
+)
+
+const testFile = `version: v1
+base: adoptopenjdk/openjdk8:slim
+target: local.dev/myorg/myapp
+pushInsecure: true
+annotations:
+  org.opencontainers.image.title: myapp
+mappings:
+  - source: ./dist
+    destination: /deployments
+    chown: "185:0"
+    chmod: "0644"
+    exclude: ["*.map"]
+    recursive: true
+  - source: image://local.dev/myorg/tools:1.0!/usr/bin/helper
+    destination: /usr/local/bin
+  - source: archive://app.tar.gz
+    destination: /opt/app
+  - source: layer://deps.tar.gz,annotation=org.opencontainers.image.title=deps
+files:
+  - path: /etc/myapp/app.conf
+    mode: "0600"
+    source: app.conf
+  - path: /etc/myapp/profile
+    content: production
+remove:
+  - /usr/share/doc/
+config:
+  clearEntrypoint: true
+  runAs: "185"
+`
+
+func TestParse(t *testing.T) {
+	file, err := Parse("spectrum.yaml", []byte(testFile))
+	assert.NoError(t, err)
+
+	options, err := file.Options("/src")
+	assert.NoError(t, err)
+	assert.Equal(t, "adoptopenjdk/openjdk8:slim", options.Base)
+	assert.Equal(t, "local.dev/myorg/myapp", options.Target)
+	assert.True(t, options.PushInsecure)
+	assert.Equal(t, map[string]string{"org.opencontainers.image.title": "myapp"}, options.Annotations)
+	assert.Equal(t, []string{"/usr/share/doc/"}, options.Remove)
+	assert.True(t, options.ClearEntrypoint)
+	assert.Equal(t, "185", options.RunAs)
+
+	uid, gid, mode, recursive := 185, 0, fs.FileMode(0o644), true
+	fileMode := fs.FileMode(0o600)
+	assert.Equal(t, []builder.Mapping{
+		{Source: filepath.Join("/src", "dist"), Destination: "/deployments", UID: &uid, GID: &gid, Mode: &mode, Exclude: []string{"*.map"}, Recursive: &recursive},
+		{Source: "image://local.dev/myorg/tools:1.0!/usr/bin/helper", Destination: "/usr/local/bin"},
+		{Source: "archive://" + filepath.Join("/src", "app.tar.gz"), Destination: "/opt/app"},
+		{Source: "layer://" + filepath.Join("/src", "deps.tar.gz") + ",annotation=org.opencontainers.image.title=deps"},
+	}, options.Mappings)
+	assert.Equal(t, []builder.File{
+		{Path: "/etc/myapp/app.conf", Mode: &fileMode, Source: filepath.Join("/src", "app.conf")},
+		{Path: "/etc/myapp/profile", Content: []byte("production")},
+	}, options.Files)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "missing version", content: "target: app\n", err: "spectrum.yaml:1: version: missing version (expected v1)"},
+		{name: "unsupported version", content: "version: v2\n", err: `spectrum.yaml:1: version: unsupported version "v2" (expected v1)`},
+		{name: "unknown field", content: "version: v1\nimage: app\n", err: "line 2: field image not found"},
+		{name: "wrong type", content: "version: v1\nsquash: maybe\n", err: "line 2: cannot unmarshal"},
+		{name: "wrong chmod", content: "version: v1\nmappings:\n  - source: dist\n    destination: /app\n  - source: lib\n    destination: /lib\n    chmod: \"999\"\n",
+			err: `spectrum.yaml:7: mappings[1].chmod: expected an octal mode, e.g. 0644, got "999"`},
+		{name: "missing destination", content: "version: v1\nmappings:\n  - source: dist\n",
+			err: "spectrum.yaml:3: mappings[0].destination: missing destination"},
+		{name: "wrong chown", content: "version: v1\nmappings:\n  - source: dist\n    destination: /app\n    chown: root\n",
+			err: "spectrum.yaml:5: mappings[0].chown: expected a numeric owner in the uid[:gid] format, got root"},
+		{name: "wrong exclude", content: "version: v1\nmappings:\n  - source: dist\n    destination: /app\n    exclude: [\"*.js\", \"[\"]\n",
+			err: "spectrum.yaml:5: mappings[0].exclude[1]: wrong pattern ["},
+		{name: "file without content", content: "version: v1\nfiles:\n  - path: /etc/app.conf\n",
+			err: "spectrum.yaml:3: files[0].source: one of source and content is required"},
+		{name: "relative file", content: "version: v1\nfiles:\n  - path: etc/app.conf\n    content: x\n",
+			err: `spectrum.yaml:3: files[0].path: expected an absolute file path, got "etc/app.conf"`},
+		{name: "relative remove", content: "version: v1\nremove:\n  - /tmp\n  - tmp\n",
+			err: "spectrum.yaml:4: remove[1]: expected an absolute path, got tmp"},
+		{name: "squash", content: "version: v1\nsquash: true\nsquashAdded: true\n",
+			err: "spectrum.yaml:3: squashAdded: only one of squash and squashAdded can be specified"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse("spectrum.yaml", []byte(test.content))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "spectrum-buildfile-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	name := filepath.Join(tmpDir, "spectrum.yaml")
+	assert.NoError(t, os.WriteFile(name, []byte(testFile), 0o644))
+
+	file, err := Load(name)
+	assert.NoError(t, err)
+	assert.Equal(t, "local.dev/myorg/myapp", file.Target)
+
+	_, err = Load(filepath.Join(tmpDir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestImageOptions(t *testing.T) {
+	file, err := Parse("spectrum.yaml", []byte(`version: v1
+base: adoptopenjdk/openjdk8:slim
+pushInsecure: true
+annotations:
+  team: core
+  tier: backend
+mappings:
+  - source: ./shared
+    destination: /deployments/lib/
+config:
+  runAs: "185"
+images:
+  - target: local.dev/myorg/orders
+    mappings:
+      - source: ./orders
+        destination: /deployments/
+  - target: local.dev/myorg/payments
+    base: adoptopenjdk/openjdk11:slim
+    annotations:
+      tier: payments
+    config:
+      clearEntrypoint: true
+`))
+	assert.NoError(t, err)
+
+	images, err := file.ImageOptions("/src")
+	assert.NoError(t, err)
+	assert.Len(t, images, 2)
+
+	assert.Equal(t, "local.dev/myorg/orders", images[0].Target)
+	assert.Equal(t, "adoptopenjdk/openjdk8:slim", images[0].Base)
+	assert.True(t, images[0].PushInsecure)
+	assert.Equal(t, "185", images[0].RunAs)
+	assert.Equal(t, map[string]string{"team": "core", "tier": "backend"}, images[0].Annotations)
+	assert.Equal(t, []builder.Mapping{
+		{Source: filepath.Join("/src", "shared"), Destination: "/deployments/lib/"},
+		{Source: filepath.Join("/src", "orders"), Destination: "/deployments/"},
+	}, images[0].Mappings)
+
+	assert.Equal(t, "local.dev/myorg/payments", images[1].Target)
+	assert.Equal(t, "adoptopenjdk/openjdk11:slim", images[1].Base)
+	assert.True(t, images[1].ClearEntrypoint)
+	assert.Equal(t, map[string]string{"team": "core", "tier": "payments"}, images[1].Annotations)
+	assert.Equal(t, []builder.Mapping{
+		{Source: filepath.Join("/src", "shared"), Destination: "/deployments/lib/"},
+	}, images[1].Mappings)
+
+	file, err = Parse("spectrum.yaml", []byte(testFile))
+	assert.NoError(t, err)
+	images, err = file.ImageOptions("/src")
+	assert.NoError(t, err)
+	assert.Len(t, images, 1)
+	assert.Equal(t, "local.dev/myorg/myapp", images[0].Target)
+}
+
+func TestImageErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "top-level target", content: "version: v1\ntarget: app\nimages:\n  - target: other\n",
+			err: "spectrum.yaml:2: target: the top-level target can't be set together with images"},
+		{name: "missing target", content: "version: v1\nimages:\n  - target: app\n  - base: other\n",
+			err: "spectrum.yaml:4: images[1].target: missing target"},
+		{name: "duplicate target", content: "version: v1\nimages:\n  - target: app\n  - target: app\n",
+			err: "spectrum.yaml:4: images[1].target: duplicate target app"},
+		{name: "wrong mapping", content: "version: v1\nimages:\n  - target: app\n    mappings:\n      - source: dist\n        destination: /app\n        chown: root\n",
+			err: "spectrum.yaml:7: images[0].mappings[0].chown: expected a numeric owner"},
+		{name: "inherited squash", content: "version: v1\nsquash: true\nimages:\n  - target: app\n    squashAdded: true\n",
+			err: "spectrum.yaml:5: images[0].squashAdded: only one of squash and squashAdded can be specified"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse("spectrum.yaml", []byte(test.content))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
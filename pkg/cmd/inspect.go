@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/spf13/cobra"
+)
+
+// newInspectCommand creates the command showing the manifest, configuration and layers of an image, or the
+// platforms of an index
+func newInspectCommand(options *CommandOptions) *cobra.Command {
+	var output string
+
+	inspect := cobra.Command{
+		Use:   "inspect <image>",
+		Short: "Show the manifest, configuration, history and layers of an image, or the platforms of an index",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("wrong output format %q, expected table or json", output)
+			}
+			logger, err := newLogger(cmd.ErrOrStderr(), cmd.ErrOrStderr(), options.logFormat, options.logLevel)
+			if err != nil {
+				return err
+			}
+			routeRegistryLogs(logger)
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inspection, err := builder.Inspect(cmd.Context(), args[0], options.Options)
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(inspection)
+			}
+			printInspection(cmd.OutOrStdout(), inspection)
+			return nil
+		},
+	}
+
+	inspect.Flags().StringVarP(&output, "output", "o", "table", "The output format, one of table or json")
+	inspect.Flags().BoolVarP(&options.PullInsecure, "pull-insecure", "", false, "If the image is hosted in an insecure registry")
+	inspect.Flags().StringVarP(&options.PullConfigDir, "pull-config-dir", "", "", "A directory containing the docker config.json file that will be used for pulling the image, in case authentication is required")
+	return &inspect
+}
+
+// printInspection prints the inspected image or index as tables
+func printInspection(out io.Writer, inspection *builder.Inspection) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Reference:\t%s\n", inspection.Reference)
+	fmt.Fprintf(w, "Digest:\t%s\n", inspection.Digest)
+	fmt.Fprintf(w, "Media type:\t%s\n", inspection.MediaType)
+	fmt.Fprintf(w, "Size:\t%s\n", formatBytes(inspection.Size))
+
+	if index := inspection.Index; index != nil {
+		w.Flush()
+		printAnnotations(out, index.Annotations)
+		fmt.Fprintf(out, "\nManifests (%d):\n", len(index.Manifests))
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  PLATFORM\tDIGEST\tSIZE\tMEDIA TYPE")
+		for _, manifest := range index.Manifests {
+			platform := "-"
+			if manifest.Platform != nil {
+				platform = manifest.Platform.String()
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", platform, manifest.Digest, formatBytes(manifest.Size), manifest.MediaType)
+		}
+		w.Flush()
+		return
+	}
+
+	config := inspection.Config
+	if platform := config.Platform(); platform != nil {
+		fmt.Fprintf(w, "Platform:\t%s\n", platform)
+	}
+	if !config.Created.IsZero() {
+		fmt.Fprintf(w, "Created:\t%s\n", config.Created.UTC().Format(time.RFC3339))
+	}
+	w.Flush()
+	printAnnotations(out, inspection.Manifest.Annotations)
+
+	fmt.Fprintln(out, "\nConfig:")
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "  Digest:\t%s\n", inspection.Manifest.Config.Digest)
+	fmt.Fprintf(w, "  Entrypoint:\t%s\n", strings.Join(config.Config.Entrypoint, " "))
+	fmt.Fprintf(w, "  Cmd:\t%s\n", strings.Join(config.Config.Cmd, " "))
+	fmt.Fprintf(w, "  User:\t%s\n", config.Config.User)
+	fmt.Fprintf(w, "  WorkingDir:\t%s\n", config.Config.WorkingDir)
+	ports := make([]string, 0, len(config.Config.ExposedPorts))
+	for port := range config.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	fmt.Fprintf(w, "  ExposedPorts:\t%s\n", strings.Join(ports, " "))
+	for _, env := range config.Config.Env {
+		fmt.Fprintf(w, "  Env:\t%s\n", env)
+	}
+	for _, label := range sortedPairs(config.Config.Labels) {
+		fmt.Fprintf(w, "  Label:\t%s\n", label)
+	}
+	w.Flush()
+
+	var total int64
+	fmt.Fprintf(out, "\nLayers (%d):\n", len(inspection.Manifest.Layers))
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  DIGEST\tSIZE\tMEDIA TYPE")
+	for _, layer := range inspection.Manifest.Layers {
+		total += layer.Size
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", layer.Digest, formatBytes(layer.Size), layer.MediaType)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "  Total: %s\n", formatBytes(total))
+
+	if len(config.History) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nHistory (%d):\n", len(config.History))
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  CREATED\tCREATED BY\tCOMMENT")
+	for _, history := range config.History {
+		created := "-"
+		if !history.Created.IsZero() {
+			created = history.Created.UTC().Format(time.RFC3339)
+		}
+		createdBy := history.CreatedBy
+		if history.EmptyLayer {
+			createdBy += " (empty layer)"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", created, createdBy, history.Comment)
+	}
+	w.Flush()
+}
+
+// printAnnotations prints the annotations of a manifest, if any
+func printAnnotations(out io.Writer, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nAnnotations:")
+	for _, annotation := range sortedPairs(annotations) {
+		fmt.Fprintf(out, "  %s\n", annotation)
+	}
+}
+
+// sortedPairs returns the entries of the map in the key=value format, sorted by key
+func sortedPairs(values map[string]string) []string {
+	pairs := make([]string, 0, len(values))
+	for k, v := range values {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
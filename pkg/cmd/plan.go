@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+)
+
+// printPlan prints the plan of a dry run: the layers and the configuration changes of the image, and the digests
+// it would have been written with
+func printPlan(out io.Writer, result *builder.BuildResult) {
+	plan := result.Plan
+	fmt.Fprintf(out, "Target:   %s\n", plan.Target)
+	if plan.BaseDigest != "" {
+		fmt.Fprintf(out, "Base:     %s@%s\n", result.Base, plan.BaseDigest)
+	} else {
+		fmt.Fprintln(out, "Base:     scratch")
+	}
+	fmt.Fprintf(out, "Manifest: %s (%s, %s)\n", result.Digest, plan.MediaType, formatBytes(plan.ManifestSize))
+	fmt.Fprintf(out, "Config:   %s\n", plan.ConfigDigest)
+
+	fmt.Fprintln(out, "\nLayers:")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  DIGEST\tSIZE\tREMOTE\tSOURCE")
+	var total, upload int64
+	for _, layer := range plan.Layers {
+		remote := "unknown"
+		if layer.Exists != nil && *layer.Exists {
+			remote = "exists"
+		} else if layer.Exists != nil {
+			remote = "missing"
+			upload += layer.Size
+		}
+		total += layer.Size
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", layer.Digest, formatBytes(layer.Size), remote, layer.Source)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "  %d layers, %s", len(plan.Layers), formatBytes(total))
+	if len(plan.Layers) > 0 && plan.Layers[0].Exists != nil {
+		fmt.Fprintf(out, ", %s to upload", formatBytes(upload))
+	}
+	fmt.Fprintln(out)
+
+	fmt.Fprintln(out)
+	printConfigChanges(out, plan.ConfigChanges)
+}
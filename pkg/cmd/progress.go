@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"golang.org/x/term"
+)
+
+const (
+	// ttyRefreshInterval is the minimum interval between two redraws of the progress on a terminal
+	ttyRefreshInterval = 100 * time.Millisecond
+	// logInterval is the minimum interval between two progress log lines of the same layer
+	logInterval = 5 * time.Second
+)
+
+// progressDisplay renders the progress of the layers being transferred, either redrawing a line per layer on
+// a terminal or logging it periodically otherwise. On a terminal, the logs written to the display are printed
+// above the progress lines, so that they don't interleave with them.
+type progressDisplay struct {
+	builder.NopEvents
+
+	mu     sync.Mutex
+	out    io.Writer
+	tty    bool
+	logger *slog.Logger
+	now    func() time.Time
+
+	layers     []*layerState
+	lastRender time.Time
+	lines      int
+}
+
+type layerState struct {
+	builder.LayerProgress
+	start   time.Time
+	lastLog time.Time
+}
+
+func newProgressDisplay(out io.Writer, logger *slog.Logger) *progressDisplay {
+	return &progressDisplay{out: out, tty: isTerminal(out), logger: logger, now: time.Now}
+}
+
+// isTerminal checks whether the writer is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// Write prints the logs above the progress lines, which are then drawn again
+func (d *progressDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.lines > 0 {
+		fmt.Fprintf(d.out, "\x1b[%dA\x1b[J", d.lines)
+		d.lines = 0
+	}
+	n, err := d.out.Write(p)
+	if len(d.layers) > 0 {
+		d.render(d.now())
+	}
+	return n, err
+}
+
+func (d *progressDisplay) OnLayerProgress(progress builder.LayerProgress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	layer := d.layer(progress, now)
+	if progress.Complete < layer.Complete {
+		// The layer is being read again
+		layer.start = now
+	}
+	layer.LayerProgress = progress
+	done := progress.Complete >= progress.Total
+
+	if d.tty {
+		if done || now.Sub(d.lastRender) >= ttyRefreshInterval {
+			d.render(now)
+		}
+		return
+	}
+	if done || now.Sub(layer.lastLog) >= logInterval {
+		layer.lastLog = now
+		d.log(layer, now)
+	}
+}
+
+// layer returns the state of the layer, starting a new batch of layers once all the previous ones are done
+func (d *progressDisplay) layer(progress builder.LayerProgress, now time.Time) *layerState {
+	active := false
+	for _, layer := range d.layers {
+		if layer.Operation == progress.Operation && layer.Digest == progress.Digest {
+			return layer
+		}
+		active = active || layer.Complete < layer.Total
+	}
+	if !active {
+		// The previous lines are left as they are, so that they are not overwritten by the following logs
+		d.layers = nil
+		d.lines = 0
+	}
+	layer := &layerState{start: now, lastLog: now}
+	d.layers = append(d.layers, layer)
+	return layer
+}
+
+func (d *progressDisplay) render(now time.Time) {
+	d.lastRender = now
+	if d.lines > 0 {
+		fmt.Fprintf(d.out, "\x1b[%dA", d.lines)
+	}
+	for _, layer := range d.layers {
+		fmt.Fprintf(d.out, "\x1b[2K%s\n", layer.describe(now))
+	}
+	d.lines = len(d.layers)
+}
+
+func (d *progressDisplay) log(layer *layerState, now time.Time) {
+	rate, eta := layer.rate(now)
+	d.logger.Info("Layer progress", "phase", layer.Operation, "digest", layer.Digest.String(),
+		"bytes", layer.Complete, "total", layer.Total, "rate", int64(rate), "eta", eta)
+}
+
+// rate returns the transfer rate in bytes per second and the estimated time to completion
+func (l *layerState) rate(now time.Time) (float64, time.Duration) {
+	elapsed := now.Sub(l.start).Seconds()
+	if elapsed <= 0 || l.Complete == 0 {
+		return 0, 0
+	}
+	rate := float64(l.Complete) / elapsed
+	return rate, time.Duration(float64(l.Total-l.Complete) / rate * float64(time.Second)).Round(time.Second)
+}
+
+func (l *layerState) describe(now time.Time) string {
+	rate, eta := l.rate(now)
+	status := fmt.Sprintf("%s/s, ETA %s", formatBytes(int64(rate)), eta)
+	switch {
+	case l.Complete >= l.Total:
+		status = "done"
+	case rate == 0:
+		status = "starting"
+	}
+	return fmt.Sprintf("%s %.19s %10s / %-10s %s", l.Operation, l.Digest.String(), formatBytes(l.Complete), formatBytes(l.Total), status)
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
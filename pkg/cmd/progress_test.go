@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const mib = 1 << 20
+
+var testDigest = v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0123456789abcdef", 4)}
+
+// testClock is a fake clock advanced by the tests
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func testDisplay(tty bool) (*progressDisplay, *bytes.Buffer, *bytes.Buffer, *testClock) {
+	var out, logs bytes.Buffer
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	display := newProgressDisplay(&out, slog.New(slog.NewJSONHandler(&logs, nil)))
+	display.tty = tty
+	display.now = clock.Now
+	return display, &out, &logs, clock
+}
+
+func progress(complete int64, total int64) builder.LayerProgress {
+	return builder.LayerProgress{Operation: builder.PullOperation, Digest: testDigest, Complete: complete, Total: total}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{bytes: 0, expected: "0 B"},
+		{bytes: 1023, expected: "1023 B"},
+		{bytes: 1024, expected: "1.0 KiB"},
+		{bytes: 1536, expected: "1.5 KiB"},
+		{bytes: mib - 1, expected: "1024.0 KiB"},
+		{bytes: mib, expected: "1.0 MiB"},
+		{bytes: 10 * mib, expected: "10.0 MiB"},
+		{bytes: 3 << 30, expected: "3.0 GiB"},
+		{bytes: 2 << 40, expected: "2.0 TiB"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, formatBytes(test.bytes), test.bytes)
+	}
+}
+
+func TestLayerRate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		progress builder.LayerProgress
+		elapsed  time.Duration
+		rate     float64
+		eta      time.Duration
+	}{
+		{name: "not started", progress: progress(0, 10*mib), elapsed: 2 * time.Second},
+		{name: "no time elapsed", progress: progress(mib, 10*mib)},
+		{name: "in progress", progress: progress(2*mib, 10*mib), elapsed: 2 * time.Second, rate: mib, eta: 8 * time.Second},
+		{name: "rounded eta", progress: progress(3*mib, 10*mib), elapsed: 2 * time.Second, rate: 1.5 * mib, eta: 5 * time.Second},
+		{name: "done", progress: progress(10*mib, 10*mib), elapsed: 5 * time.Second, rate: 2 * mib},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layer := &layerState{LayerProgress: test.progress, start: start}
+			rate, eta := layer.rate(start.Add(test.elapsed))
+			assert.Equal(t, test.rate, rate)
+			assert.Equal(t, test.eta, eta)
+		})
+	}
+}
+
+func TestLayerDescribe(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		progress builder.LayerProgress
+		elapsed  time.Duration
+		expected string
+	}{
+		{name: "starting", progress: progress(0, 10*mib), elapsed: time.Second,
+			expected: "pull sha256:0123456789ab        0 B / 10.0 MiB   starting"},
+		{name: "in progress", progress: progress(2*mib, 10*mib), elapsed: 2 * time.Second,
+			expected: "pull sha256:0123456789ab    2.0 MiB / 10.0 MiB   1.0 MiB/s, ETA 8s"},
+		{name: "done", progress: progress(10*mib, 10*mib), elapsed: 5 * time.Second,
+			expected: "pull sha256:0123456789ab   10.0 MiB / 10.0 MiB   done"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layer := &layerState{LayerProgress: test.progress, start: start}
+			assert.Equal(t, test.expected, layer.describe(start.Add(test.elapsed)))
+		})
+	}
+}
+
+func TestProgressDisplayLogsPeriodically(t *testing.T) {
+	display, out, logs, clock := testDisplay(false)
+
+	steps := []struct {
+		elapsed  time.Duration
+		complete int64
+	}{
+		{elapsed: 0, complete: 0},
+		{elapsed: 2 * time.Second, complete: 2 * mib},
+		{elapsed: 3 * time.Second, complete: 5 * mib},
+		{elapsed: time.Second, complete: 6 * mib},
+		{elapsed: time.Second, complete: 10 * mib},
+	}
+	for _, step := range steps {
+		clock.advance(step.elapsed)
+		display.OnLayerProgress(progress(step.complete, 10*mib))
+	}
+
+	// Without a terminal nothing is drawn, the progress is logged every 5 seconds and once done
+	assert.Empty(t, out.String())
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		record := map[string]any{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "Layer progress", records[0]["msg"])
+		assert.Equal(t, testDigest.String(), records[0]["digest"])
+		assert.Equal(t, float64(5*mib), records[0]["bytes"])
+		assert.Equal(t, float64(mib), records[0]["rate"])
+		assert.Equal(t, float64(10*mib), records[1]["bytes"])
+		assert.Equal(t, float64(0), records[1]["eta"])
+	}
+}
+
+func TestProgressDisplayRedraws(t *testing.T) {
+	display, out, logs, clock := testDisplay(true)
+	display.OnLayerProgress(progress(0, 10*mib))
+	assert.Equal(t, "\x1b[2Kpull sha256:0123456789ab        0 B / 10.0 MiB   starting\n", out.String())
+	out.Reset()
+
+	// Redraws are throttled
+	clock.advance(50 * time.Millisecond)
+	display.OnLayerProgress(progress(mib, 10*mib))
+	assert.Empty(t, out.String())
+
+	clock.advance(950 * time.Millisecond)
+	display.OnLayerProgress(progress(mib, 10*mib))
+	assert.Equal(t, "\x1b[1A\x1b[2Kpull sha256:0123456789ab    1.0 MiB / 10.0 MiB   1.0 MiB/s, ETA 9s\n", out.String())
+	out.Reset()
+
+	// Logs are printed above the progress, that is drawn again below them
+	_, err := display.Write([]byte("level=INFO msg=Pushing\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "\x1b[1A\x1b[J"+"level=INFO msg=Pushing\n"+
+		"\x1b[2Kpull sha256:0123456789ab    1.0 MiB / 10.0 MiB   1.0 MiB/s, ETA 9s\n", out.String())
+	out.Reset()
+
+	// The completion is always drawn, and the next layers start below it
+	clock.advance(10 * time.Millisecond)
+	display.OnLayerProgress(progress(10*mib, 10*mib))
+	assert.Equal(t, "\x1b[1A\x1b[2Kpull sha256:0123456789ab   10.0 MiB / 10.0 MiB   done\n", out.String())
+	out.Reset()
+
+	next := progress(0, mib)
+	next.Digest = v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("f", 64)}
+	clock.advance(time.Second)
+	display.OnLayerProgress(next)
+	assert.Equal(t, "\x1b[2Kpull sha256:ffffffffffff        0 B / 1.0 MiB    starting\n", out.String())
+	assert.Empty(t, logs.String())
+}
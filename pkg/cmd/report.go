@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/pkg/errors"
+)
+
+// maxReportedFiles is the maximum number of files listed per layer in the text reports
+const maxReportedFiles = 50
+
+// writeReports writes the reports of the builds in the given format, text or json, to the report file, replacing
+// it, or to the output if no report file is set. A single report is written as a JSON object, several reports as
+// a JSON array.
+func writeReports(out io.Writer, format string, reportFile string, reports ...*builder.Report) error {
+	if reportFile != "" {
+		file, err := os.Create(reportFile)
+		if err != nil {
+			return errors.Wrap(err, "cannot create report file")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if len(reports) == 1 {
+			return encoder.Encode(reports[0])
+		}
+		return encoder.Encode(reports)
+	}
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		printReport(out, report)
+	}
+	return nil
+}
+
+// printReport prints the configuration changes and the files of the added layers of the report
+func printReport(out io.Writer, report *builder.Report) {
+	fmt.Fprintf(out, "Image: %s@%s\n", report.Target, report.Digest)
+	if report.BaseDigest != "" {
+		fmt.Fprintf(out, "Base:  %s@%s\n", report.Base, report.BaseDigest)
+	} else {
+		fmt.Fprintln(out, "Base:  scratch")
+	}
+	fmt.Fprintln(out)
+	printConfigChanges(out, report.ConfigChanges)
+
+	fmt.Fprintf(out, "\nAdded layers (%d):\n", len(report.Layers))
+	for _, layer := range report.Layers {
+		fmt.Fprintf(out, "  %s %s %s\n", layer.Digest, formatBytes(layer.Size), layer.Source)
+		for i, file := range layer.Files {
+			if i == maxReportedFiles {
+				fmt.Fprintf(out, "    ... and %d more\n", len(layer.Files)-maxReportedFiles)
+				break
+			}
+			switch file.Type {
+			case "removed":
+				fmt.Fprintf(out, "    - %s\n", file.Path)
+			case "file":
+				fmt.Fprintf(out, "    + %s (%s, %s)\n", file.Path, file.Mode, formatBytes(file.Size))
+			case "symlink", "hardlink":
+				fmt.Fprintf(out, "    + %s -> %s\n", file.Path, file.Link)
+			default:
+				fmt.Fprintf(out, "    + %s (%s, %s)\n", file.Path, file.Type, file.Mode)
+			}
+		}
+	}
+}
+
+// printConfigChanges prints the changes to the configuration of the base image, as removed and added values
+func printConfigChanges(out io.Writer, changes []builder.ConfigChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "Config: unchanged")
+		return
+	}
+	fmt.Fprintln(out, "Config changes:")
+	for _, change := range changes {
+		field := change.Field
+		if change.Key != "" {
+			field += " " + change.Key
+		}
+		fmt.Fprintf(out, "  %s: %s\n", field, change.Change)
+		if change.Base != "" || change.Change == "changed" {
+			fmt.Fprintf(out, "    - %s\n", change.Base)
+		}
+		if change.Image != "" || change.Change == "changed" {
+			fmt.Fprintf(out, "    + %s\n", change.Image)
+		}
+	}
+}
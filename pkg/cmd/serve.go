@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/container-tools/spectrum/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+// newServeCommand creates the command serving the HTTP build API, sharing the logging and tracing flags of the root
+// command
+func newServeCommand(options *CommandOptions) *cobra.Command {
+	var address string
+	var maxUploadSize int64
+	var allowedTargets []string
+	var allowInsecure bool
+
+	serve := cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API building images from the uploaded sources",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			logger, err := newLogger(cmd.ErrOrStderr(), cmd.ErrOrStderr(), options.logFormat, options.logLevel)
+			if err != nil {
+				return err
+			}
+			routeRegistryLogs(logger)
+			provider, shutdown, err := newTracerProvider(cmd.Context(), options.otlpEndpoint, options.traceFile)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if shutdownErr := shutdown(); err == nil {
+					err = shutdownErr
+				}
+			}()
+			if provider != nil {
+				options.TracerProvider = provider
+			}
+
+			srv := server.New(options.Options, logger)
+			srv.MaxUploadSize = maxUploadSize
+			srv.AllowedTargets = allowedTargets
+			srv.AllowInsecure = allowInsecure
+			if err := srv.Validate(); err != nil {
+				return err
+			}
+			if len(allowedTargets) == 0 {
+				logger.Warn("Any target is allowed, the build requests can push to any registry with the server credentials (see --allowed-target)")
+			}
+			httpServer := &http.Server{
+				Addr:              address,
+				Handler:           srv.Handler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			errs := make(chan error, 1)
+			go func() {
+				logger.Info("Serving the build API", "address", address)
+				errs <- httpServer.ListenAndServe()
+			}()
+
+			select {
+			case err := <-errs:
+				return err
+			case <-ctx.Done():
+			}
+			logger.Info("Shutting down")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			return httpServer.Shutdown(shutdownCtx)
+		},
+	}
+
+	serve.Flags().StringVar(&address, "address", ":8080", "The address the API listens on")
+	serve.Flags().Int64Var(&maxUploadSize, "max-upload-size", server.DefaultMaxUploadSize, "The maximum size in bytes of a build request, sources included")
+	serve.Flags().StringArrayVar(&allowedTargets, "allowed-target", nil, "A registry, e.g. registry.example.com, or a repository prefix, e.g. registry.example.com/team, the targets are limited to (can be repeated, any target is allowed if not set)")
+	serve.Flags().BoolVar(&allowInsecure, "allow-insecure", false, "Allow the build requests to pull from and push to insecure registries")
+	serve.Flags().StringVarP(&options.PullConfigDir, "pull-config-dir", "", "", "A directory containing the docker config.json file that will be used for pulling the base images, in case authentication is required")
+	serve.Flags().StringVarP(&options.PushConfigDir, "push-config-dir", "", "", "A directory containing the docker config.json file that will be used for pushing the target images, in case authentication is required")
+	return &serve
+}
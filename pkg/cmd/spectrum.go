@@ -1,20 +1,51 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/container-tools/spectrum/pkg/buildfile"
+	"github.com/container-tools/spectrum/pkg/dockerfile"
 	"github.com/container-tools/spectrum/pkg/util"
+	"github.com/google/go-containerregistry/pkg/logs"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type CommandOptions struct {
 	builder.Options
 
 	annotationList []string
+	copyList       []string
+	fileList       []string
+	contentList    []string
 	quiet          bool
+	buildFile      string
+	parallelism    int
+	dockerfile     string
+	watch          bool
+	watchDebounce  time.Duration
+	report         string
+	reportFile     string
+	contextDir     string
+	buildArgList   []string
+	builds         []builder.Options
+	logFormat      string
+	logLevel       string
+	otlpEndpoint   string
+	traceFile      string
 }
 
 func Spectrum() *cobra.Command {
@@ -24,24 +55,66 @@ func Spectrum() *cobra.Command {
 	}
 
 	options := CommandOptions{}
+	cmd.PersistentFlags().StringVar(&options.logFormat, "log-format", "text", "The format of the logs, one of text or json")
+	cmd.PersistentFlags().StringVar(&options.logLevel, "log-level", "info", "The minimum level of the logs, one of debug, info, warn or error")
+	cmd.PersistentFlags().StringVar(&options.otlpEndpoint, "otlp-endpoint", "", "The OTLP/HTTP endpoint the traces are exported to, e.g. http://localhost:4318 (defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable)")
+	cmd.PersistentFlags().StringVar(&options.traceFile, "trace-file", "", "A file the traces are written to as JSON, instead of being exported to an OTLP endpoint")
+
 	build := cobra.Command{
 		Use:   "build",
 		Short: "Build an image and publish it",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 {
+			if options.buildFile == "" && options.dockerfile == "" && len(args) == 0 && len(options.copyList) == 0 && len(options.Remove) == 0 &&
+				len(options.fileList) == 0 && len(options.contentList) == 0 {
 				return errors.New("at least one argument is required")
 			}
 			for _, dir := range args {
-				parts := strings.Split(dir, ":")
-				if len(parts) != 2 {
-					return errors.New("wrong format for dir " + dir + ". Expected: \"local:remote\"")
+				mapping, err := builder.ParseMapping(dir)
+				if err != nil {
+					return err
+				}
+				options.Mappings = append(options.Mappings, mapping)
+			}
+			for _, c := range options.copyList {
+				mapping, err := builder.ParseCopyMapping(c)
+				if err != nil {
+					return err
 				}
+				options.Mappings = append(options.Mappings, mapping)
 			}
 
-			// Configure output
+			if options.Squash && options.SquashAdded {
+				return errors.New("only one of --squash and --squash-added can be specified")
+			}
+			if options.reportFile != "" && options.report == "" {
+				options.report = "text"
+			}
+			if options.report != "" && options.report != "text" && options.report != "json" {
+				return fmt.Errorf("wrong report format %q, expected text or json", options.report)
+			}
+			options.Report = options.report != ""
+
+			// Configure output. The progress is drawn on stderr when it's a terminal, so the logs printed to the
+			// same terminal go through the progress display not to interleave with it.
+			display := newProgressDisplay(cmd.ErrOrStderr(), nil)
+			logOut, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+			if display.tty {
+				errOut = display
+				if isTerminal(logOut) {
+					logOut = display
+				}
+			}
+			logger, err := newLogger(logOut, errOut, options.logFormat, options.logLevel)
+			if err != nil {
+				return err
+			}
+			display.logger = logger
 			if !options.quiet {
+				routeRegistryLogs(logger)
 				options.Stdout = cmd.OutOrStdout()
 				options.Stderr = cmd.ErrOrStderr()
+				options.Logger = logger
+				options.Events = display
 			}
 
 			for _, akv := range options.annotationList {
@@ -55,30 +128,162 @@ func Spectrum() *cobra.Command {
 				options.Annotations[parts[0]] = parts[1]
 			}
 
+			for _, f := range options.fileList {
+				filePath, mode, value, err := parseFile(f)
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(value, "@") {
+					return fmt.Errorf(`wrong format for the file: expected "path[:mode]=@local-file", got %q`, f)
+				}
+				options.Files = append(options.Files, builder.File{Path: filePath, Mode: mode, Source: value[1:]})
+			}
+			for _, f := range options.contentList {
+				filePath, mode, value, err := parseFile(f)
+				if err != nil {
+					return err
+				}
+				options.Files = append(options.Files, builder.File{Path: filePath, Mode: mode, Content: []byte(value)})
+			}
+			options.Stdin = cmd.InOrStdin()
+
+			options.builds = []builder.Options{options.Options}
+			switch {
+			case options.buildFile != "" && options.dockerfile != "":
+				return errors.New("only one of --build-file and --dockerfile can be specified")
+			case options.buildFile != "":
+				if options.builds, err = loadBuildFile(cmd.Flags(), options.Options, options.buildFile); err != nil {
+					return err
+				}
+			case options.dockerfile != "":
+				build, err := loadDockerfile(cmd.Flags(), options.Options, options.dockerfile, options.contextDir, options.buildArgList)
+				if err != nil {
+					return err
+				}
+				options.builds = []builder.Options{build}
+			}
 			return nil
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
-			digest, err := builder.Build(options.Options, args...)
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			provider, shutdown, err := newTracerProvider(cmd.Context(), options.otlpEndpoint, options.traceFile)
 			if err != nil {
 				return err
 			}
-			fmt.Fprintln(cmd.OutOrStdout(), digest)
+			defer func() {
+				if shutdownErr := shutdown(); err == nil {
+					err = shutdownErr
+				}
+			}()
+			if provider != nil {
+				options.TracerProvider = provider
+			}
+
+			if options.watch {
+				if len(options.builds) > 1 {
+					return errors.New("--watch can't be used with a build file describing multiple images")
+				}
+				ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				return builder.Watch(ctx, options.builds[0], options.watchDebounce, func(result *builder.BuildResult, err error) {
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "Build failed: %v\n", err)
+						return
+					}
+					if result.Plan != nil {
+						printPlan(cmd.OutOrStdout(), result)
+					} else {
+						fmt.Fprintln(cmd.OutOrStdout(), result.Digest)
+					}
+					if result.Report != nil {
+						if err := writeReports(cmd.OutOrStdout(), options.report, options.reportFile, result.Report); err != nil {
+							fmt.Fprintf(cmd.ErrOrStderr(), "Report failed: %v\n", err)
+						}
+					}
+				})
+			}
+
+			if len(options.builds) == 1 {
+				result, err := builder.BuildContext(cmd.Context(), options.builds[0])
+				if err != nil {
+					return err
+				}
+				if result.Plan != nil {
+					printPlan(cmd.OutOrStdout(), result)
+				} else {
+					fmt.Fprintln(cmd.OutOrStdout(), result.Digest)
+				}
+				if result.Report != nil {
+					return writeReports(cmd.OutOrStdout(), options.report, options.reportFile, result.Report)
+				}
+				return nil
+			}
+
+			for i := range options.builds {
+				if options.builds[i].Logger != nil {
+					options.builds[i].Logger = options.builds[i].Logger.With("target", options.builds[i].Target)
+				}
+			}
+			failed := 0
+			var reports []*builder.Report
+			for _, result := range builder.BuildBatch(cmd.Context(), options.parallelism, options.builds...) {
+				if result.Err != nil {
+					failed++
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", result.Target, result.Err)
+					continue
+				}
+				if result.Result.Report != nil {
+					reports = append(reports, result.Result.Report)
+				}
+				if result.Result.Plan != nil {
+					printPlan(cmd.OutOrStdout(), result.Result)
+					fmt.Fprintln(cmd.OutOrStdout())
+					continue
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), result.Target, result.Result.Digest)
+			}
+			if len(reports) > 0 {
+				if err := writeReports(cmd.OutOrStdout(), options.report, options.reportFile, reports...); err != nil {
+					return err
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d images failed to build", failed, len(options.builds))
+			}
 			return nil
 		},
 	}
 
+	build.Flags().StringVarP(&options.buildFile, "build-file", "f", "", "A spectrum.yaml file describing the build. Flags override the values of the file, while mappings, files and paths to remove are added to the ones of the file")
+	build.Flags().StringVar(&options.dockerfile, "dockerfile", "", "A Dockerfile describing the build, using only the FROM, ARG, COPY, ADD, ENV, LABEL, WORKDIR, USER, ENTRYPOINT, CMD and EXPOSE instructions. Flags override the values of the Dockerfile")
+	build.Flags().StringVar(&options.contextDir, "context", "", "The directory the sources of the Dockerfile are relative to (defaults to the directory of the Dockerfile)")
+	build.Flags().StringArrayVar(&options.buildArgList, "build-arg", nil, "A build argument of the Dockerfile in the KEY=value format, or KEY to read its value from the environment. Can be repeated")
+	build.Flags().BoolVar(&options.watch, "watch", false, "Build the image again each time the local sources change, until interrupted")
+	build.Flags().DurationVar(&options.watchDebounce, "watch-debounce", builder.DefaultWatchDebounce, "The time without changes waited for before building again in watch mode")
+	build.Flags().BoolVar(&options.DryRun, "dry-run", false, "Build the image and print its plan (layers, sizes, configuration changes, digests and layers already in the target registry) without writing anything to the target")
+	build.Flags().StringVar(&options.report, "report", "", "Print a report of the changes to the base image (configuration, added layers and files), in the text or json format")
+	build.Flags().StringVar(&options.reportFile, "report-file", "", "A file the report is written to instead of the standard output (the format defaults to text)")
+	build.Flags().IntVar(&options.parallelism, "parallelism", 4, "The maximum number of images of the build file built concurrently")
 	build.Flags().StringVarP(&options.Base, "base", "b", "", "Base container image to use")
-	build.Flags().StringVarP(&options.Target, "target", "t", "", "Target container image to use")
+	build.Flags().StringVarP(&options.Target, "target", "t", "", "Target container image to use, or oci:path[:tag] and docker-archive:path:image to write it to a local file, or daemon:image to load it into the local daemon")
 	build.Flags().BoolVarP(&options.PullInsecure, "pull-insecure", "", false, "If the base image is hosted in an insecure registry")
 	build.Flags().BoolVarP(&options.PushInsecure, "push-insecure", "", false, "If the target image will be pushed to an insecure registry")
 	build.Flags().StringVarP(&options.PullConfigDir, "pull-config-dir", "", "", "A directory containing the docker config.json file that will be used for pulling the base image, in case authentication is required")
 	build.Flags().StringVarP(&options.PushConfigDir, "push-config-dir", "", "", "A directory containing the docker config.json file that will be used for pushing the target image, in case authentication is required")
+	build.Flags().StringVar(&options.DaemonHost, "daemon-host", "", "The address of the Docker or Podman daemon used by daemon: targets, e.g. unix:///run/podman/podman.sock (defaults to DOCKER_HOST)")
 	build.Flags().StringSliceVarP(&options.annotationList, "annotations", "a", nil, "A list of annotations in the key=value format to add to the final image")
 	build.Flags().BoolVarP(&options.quiet, "quiet", "q", false, "Do not print logs to stdout and stderr")
 	build.Flags().BoolVarP(&options.Recursive, "recursive", "r", false, "Copy content from the source filesystem directory recursively")
 	build.Flags().BoolVar(&options.ClearEntrypoint, "clear-entrypoint", false, "Clear any entrypoint defined")
 	build.Flags().StringVar(&options.RunAs, "run-as", "", "User id/name used to run the container image")
+	build.Flags().BoolVar(&options.Squash, "squash", false, "Flatten the base image and the added layers into a single layer")
+	build.Flags().BoolVar(&options.SquashAdded, "squash-added", false, "Flatten the added layers into a single layer, keeping the base image layers shared")
+	build.Flags().StringArrayVar(&options.Remove, "remove", nil, "A path to remove from the base image. A path ending with / keeps the directory and removes its content. Can be repeated")
+	build.Flags().StringArrayVar(&options.copyList, "copy", nil, "A mapping in the src=local,dst=remote[,chown=uid:gid][,chmod=mode][,exclude=pattern][,recursive=bool] format, added after the mappings passed as arguments. Can be repeated")
+	build.Flags().StringArrayVar(&options.fileList, "file", nil, "A file to create in the image from a local file, in the /path/in/image[:mode]=@local-file format. Can be repeated")
+	build.Flags().StringArrayVar(&options.contentList, "file-content", nil, "A file to create in the image with the given content, in the /path/in/image[:mode]=content format. Can be repeated")
 	cmd.AddCommand(&build)
+	cmd.AddCommand(newServeCommand(&options))
+	cmd.AddCommand(newInspectCommand(&options))
 
 	version := cobra.Command{
 		Use:   "version",
@@ -91,3 +296,192 @@ func Spectrum() *cobra.Command {
 
 	return &cmd
 }
+
+// loadBuildFile returns the options of the builds of each image described by the build file, overridden by the
+// flags
+func loadBuildFile(flags *pflag.FlagSet, options builder.Options, name string) ([]builder.Options, error) {
+	file, err := buildfile.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	images, err := file.ImageOptions(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(images) > 1 {
+		if flags.Changed("target") {
+			return nil, errors.New("--target can't be used with a build file describing multiple images")
+		}
+		for _, mapping := range options.Mappings {
+			if mapping.Source == builder.StdinSource {
+				return nil, errors.New("the standard input can't be read by multiple images")
+			}
+		}
+	}
+
+	builds := make([]builder.Options, 0, len(images))
+	for _, image := range images {
+		builds = append(builds, mergeOptions(flags, image, options))
+	}
+	return builds, nil
+}
+
+// loadDockerfile returns the options of the build described by the Dockerfile, overridden by the flags
+func loadDockerfile(flags *pflag.FlagSet, options builder.Options, name string, contextDir string, buildArgList []string) (builder.Options, error) {
+	buildArgs := make(map[string]string, len(buildArgList))
+	for _, arg := range buildArgList {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			// As Docker does, the value of an argument without one is read from the environment
+			if value, found = os.LookupEnv(key); !found {
+				continue
+			}
+		}
+		buildArgs[key] = value
+	}
+	if contextDir == "" {
+		contextDir = filepath.Dir(name)
+	}
+	// The variables the Dockerfile doesn't declare, e.g. PATH, are resolved against the base image the build uses
+	baseEnv := func(base string) ([]string, error) {
+		pullOptions := options
+		if !flags.Changed("base") {
+			pullOptions.Base = base
+		}
+		img, err := builder.Pull(pullOptions)
+		if err != nil {
+			return nil, err
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		return config.Config.Env, nil
+	}
+	dockerfileOptions, err := dockerfile.Load(name, contextDir, buildArgs, baseEnv)
+	if err != nil {
+		return options, err
+	}
+	return mergeOptions(flags, dockerfileOptions, options), nil
+}
+
+// mergeOptions returns the options of a build described by a file, overridden by the flags. Mappings, files and
+// paths to remove of the command line are added after the ones of the file, and annotations replace the ones of
+// the file with the same key.
+func mergeOptions(flags *pflag.FlagSet, file builder.Options, options builder.Options) builder.Options {
+	build := options
+	fromFile := func(flag string, apply func()) {
+		if !flags.Changed(flag) {
+			apply()
+		}
+	}
+	fromFile("base", func() { build.Base = file.Base })
+	fromFile("target", func() { build.Target = file.Target })
+	fromFile("pull-insecure", func() { build.PullInsecure = file.PullInsecure })
+	fromFile("push-insecure", func() { build.PushInsecure = file.PushInsecure })
+	fromFile("pull-config-dir", func() { build.PullConfigDir = file.PullConfigDir })
+	fromFile("push-config-dir", func() { build.PushConfigDir = file.PushConfigDir })
+	fromFile("daemon-host", func() { build.DaemonHost = file.DaemonHost })
+	fromFile("recursive", func() { build.Recursive = file.Recursive })
+	fromFile("clear-entrypoint", func() { build.ClearEntrypoint = file.ClearEntrypoint })
+	fromFile("run-as", func() { build.RunAs = file.RunAs })
+	// The squash flags select a single flattening mode, so either of them overrides both values of the file
+	if !flags.Changed("squash") && !flags.Changed("squash-added") {
+		build.Squash = file.Squash
+		build.SquashAdded = file.SquashAdded
+	}
+	build.Entrypoint = file.Entrypoint
+	build.Cmd = file.Cmd
+	build.WorkingDir = file.WorkingDir
+	build.Env = file.Env
+	build.Labels = file.Labels
+	build.ExposedPorts = file.ExposedPorts
+
+	if len(file.Annotations) > 0 {
+		build.Annotations = file.Annotations
+		for k, v := range options.Annotations {
+			build.Annotations[k] = v
+		}
+	}
+	build.Mappings = append(file.Mappings, options.Mappings...)
+	build.Files = append(file.Files, options.Files...)
+	build.Remove = append(file.Remove, options.Remove...)
+	return build
+}
+
+// newLogger creates the logger of the build in the given format, text or json, and level
+func newLogger(out io.Writer, errOut io.Writer, format, level string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("wrong log level %q: expected one of debug, info, warn or error", level)
+	}
+	handlerOptions := &slog.HandlerOptions{Level: logLevel}
+	var newHandler func(io.Writer) slog.Handler
+	switch format {
+	case "text":
+		newHandler = func(w io.Writer) slog.Handler { return slog.NewTextHandler(w, handlerOptions) }
+	case "json":
+		newHandler = func(w io.Writer) slog.Handler { return slog.NewJSONHandler(w, handlerOptions) }
+	default:
+		return nil, fmt.Errorf("wrong log format %q: expected one of text or json", format)
+	}
+	if out == errOut {
+		return slog.New(newHandler(out)), nil
+	}
+	return slog.New(splitHandler{out: newHandler(out), err: newHandler(errOut)}), nil
+}
+
+// routeRegistryLogs sends the warnings of go-containerregistry, e.g. about the credentials or the registries, to
+// the logger, and its progress messages at the debug level. Its loggers are global, so only the command can set them.
+func routeRegistryLogs(logger *slog.Logger) {
+	logs.Warn = slog.NewLogLogger(logger.Handler(), slog.LevelWarn)
+	logs.Progress = slog.NewLogLogger(logger.Handler(), slog.LevelDebug)
+}
+
+// splitHandler writes the warnings and the errors to the err handler, and the other logs to the out handler
+type splitHandler struct {
+	out slog.Handler
+	err slog.Handler
+}
+
+func (h splitHandler) handler(level slog.Level) slog.Handler {
+	if level >= slog.LevelWarn {
+		return h.err
+	}
+	return h.out
+}
+
+func (h splitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler(level).Enabled(ctx, level)
+}
+
+func (h splitHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler(record.Level).Handle(ctx, record)
+}
+
+func (h splitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return splitHandler{out: h.out.WithAttrs(attrs), err: h.err.WithAttrs(attrs)}
+}
+
+func (h splitHandler) WithGroup(name string) slog.Handler {
+	return splitHandler{out: h.out.WithGroup(name), err: h.err.WithGroup(name)}
+}
+
+// parseFile parses a file in the path[:mode]=value format
+func parseFile(spec string) (filePath string, mode *fs.FileMode, value string, err error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return "", nil, "", fmt.Errorf(`wrong format for the file: expected "path[:mode]=value", got %q`, spec)
+	}
+	filePath = parts[0]
+	if idx := strings.LastIndex(filePath, ":"); idx >= 0 {
+		m, err := strconv.ParseUint(filePath[idx+1:], 8, 32)
+		if err != nil {
+			return "", nil, "", fmt.Errorf("wrong mode for the file %q: %v", spec, err)
+		}
+		filePath = filePath[:idx]
+		fileMode := fs.FileMode(m)
+		mode = &fileMode
+	}
+	return filePath, mode, parts[1], nil
+}
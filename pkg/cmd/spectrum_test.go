@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/logs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteRegistryLogs(t *testing.T) {
+	warn, progress := logs.Warn, logs.Progress
+	defer func() {
+		logs.Warn, logs.Progress = warn, progress
+	}()
+
+	var out, errOut bytes.Buffer
+	logger, err := newLogger(&out, &errOut, "text", "info")
+	assert.NoError(t, err)
+	routeRegistryLogs(logger)
+
+	logs.Warn.Printf("no matching credentials were found for %q", "registry.example.com")
+	logs.Progress.Printf("pushing layer")
+	assert.Contains(t, errOut.String(), "level=WARN")
+	assert.Contains(t, errOut.String(), `no matching credentials were found for \"registry.example.com\"`)
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newTracerProvider creates a provider exporting the spans to the OTLP endpoint, either given or configured with
+// the standard OTEL_EXPORTER_OTLP_* environment variables, or as JSON to the trace file. It returns nil if tracing
+// is not configured, otherwise the returned function flushes the spans and releases the provider.
+func newTracerProvider(ctx context.Context, otlpEndpoint, traceFile string) (*sdktrace.TracerProvider, func() error, error) {
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch {
+	case traceFile != "":
+		f, err := os.Create(traceFile)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot create trace file %s", traceFile)
+		}
+		file = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	case otlpEndpoint != "":
+		var err error
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(otlpEndpoint))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot create OTLP exporter for %s", otlpEndpoint)
+		}
+	case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "":
+		var err error
+		exporter, err = otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "cannot create OTLP exporter")
+		}
+	default:
+		return nil, func() error { return nil }, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "spectrum"))),
+	)
+	return provider, func() error {
+		err := provider.Shutdown(context.Background())
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,476 @@
+// Package dockerfile converts the Dockerfiles that only add files and configure the image, without running any
+// command, into builds
+package dockerfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/pkg/errors"
+)
+
+var (
+	escapeDirective = regexp.MustCompile(`^#\s*escape\s*=\s*(\S*)\s*$`)
+	exposedPort     = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(/(tcp|udp|sctp))?$`)
+	archiveSuffixes = []string{".tar", ".tar.gz", ".tgz"}
+)
+
+type instruction struct {
+	line    int
+	keyword string
+	args    string
+}
+
+// BaseEnv returns the environment variables of the base image, in the KEY=value format, e.g. by pulling its
+// configuration
+type BaseEnv func(base string) ([]string, error)
+
+// Load reads the Dockerfile and returns the options of the build it describes. The local sources are relative to
+// the context directory, and the build arguments override the defaults of the ARG instructions. The variables not
+// declared by the Dockerfile, e.g. PATH, are resolved against the environment of the base image returned by baseEnv,
+// or are empty if it's nil.
+func Load(name string, contextDir string, buildArgs map[string]string, baseEnv BaseEnv) (builder.Options, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return builder.Options{}, err
+	}
+	return Parse(name, data, contextDir, buildArgs, baseEnv)
+}
+
+// Parse parses the content of the named Dockerfile and returns the options of the build it describes. Only the FROM,
+// ARG, COPY, ADD, ENV, LABEL, WORKDIR, USER, ENTRYPOINT, CMD and EXPOSE instructions are supported.
+func Parse(name string, data []byte, contextDir string, buildArgs map[string]string, baseEnv BaseEnv) (builder.Options, error) {
+	instructions, err := split(data)
+	if err != nil {
+		return builder.Options{}, errors.Wrapf(err, "cannot parse %s", name)
+	}
+	p := parser{
+		contextDir: contextDir,
+		buildArgs:  buildArgs,
+		getBaseEnv: baseEnv,
+		metaArgs:   make(map[string]string),
+		baseEnv:    make(map[string]string),
+		args:       make(map[string]string),
+		env:        make(map[string]string),
+		workDir:    "/",
+	}
+	for _, i := range instructions {
+		if err := p.apply(i); err != nil {
+			return builder.Options{}, fmt.Errorf("%s:%d: %s: %v", name, i.line, i.keyword, err)
+		}
+	}
+	if !p.from {
+		return builder.Options{}, errors.New(name + ": missing FROM instruction")
+	}
+	if p.options.Entrypoint != nil && !p.cmd {
+		// As Docker does, an entrypoint resets the command inherited from the base image
+		p.options.Cmd = []string{}
+	}
+	return p.options, nil
+}
+
+// split splits the Dockerfile into instructions, joining the continuation lines and skipping the comments
+func split(data []byte) ([]instruction, error) {
+	var instructions []instruction
+	var current strings.Builder
+	start := 0
+	directives := true
+	for n, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if directives {
+			if match := escapeDirective.FindStringSubmatch(trimmed); match != nil {
+				if match[1] != `\` {
+					return nil, fmt.Errorf("line %d: unsupported escape character %q", n+1, match[1])
+				}
+				continue
+			}
+			directives = strings.HasPrefix(trimmed, "#")
+		}
+		if strings.HasPrefix(trimmed, "#") || trimmed == "" {
+			continue
+		}
+		if current.Len() == 0 {
+			start = n + 1
+		}
+		if content := strings.TrimRight(line, " \t"); strings.HasSuffix(content, `\`) {
+			current.WriteString(strings.TrimSuffix(content, `\`))
+			continue
+		}
+		current.WriteString(line)
+		instructions = append(instructions, newInstruction(start, current.String()))
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		// As Docker does, a continuation at the end of the file terminates the instruction
+		instructions = append(instructions, newInstruction(start, current.String()))
+	}
+	return instructions, nil
+}
+
+func newInstruction(line int, content string) instruction {
+	keyword, args, _ := strings.Cut(strings.TrimSpace(content), " ")
+	if k, a, found := strings.Cut(keyword, "\t"); found {
+		keyword, args = k, a+" "+args
+	}
+	return instruction{line: line, keyword: strings.ToUpper(keyword), args: strings.TrimSpace(args)}
+}
+
+type parser struct {
+	contextDir string
+	buildArgs  map[string]string
+	getBaseEnv BaseEnv
+	// metaArgs are the arguments declared before FROM
+	metaArgs map[string]string
+	args     map[string]string
+	// baseEnv is the environment inherited from the base image
+	baseEnv map[string]string
+	env     map[string]string
+	workDir string
+	from    bool
+	cmd     bool
+	options builder.Options
+}
+
+// vars returns the variables available for substitution, environment variables, including the ones of the base
+// image, taking precedence over arguments
+func (p *parser) vars() map[string]string {
+	if !p.from {
+		return p.metaArgs
+	}
+	vars := make(map[string]string, len(p.args)+len(p.baseEnv)+len(p.env))
+	for k, v := range p.args {
+		vars[k] = v
+	}
+	for k, v := range p.baseEnv {
+		vars[k] = v
+	}
+	for k, v := range p.env {
+		vars[k] = v
+	}
+	return vars
+}
+
+func (p *parser) apply(i instruction) error {
+	if !p.from && i.keyword != "FROM" && i.keyword != "ARG" {
+		return errors.New("only ARG instructions can precede FROM")
+	}
+	switch i.keyword {
+	case "FROM":
+		return p.fromInstruction(i.args)
+	case "ARG":
+		return p.argInstruction(i.args)
+	case "COPY", "ADD":
+		return p.copyInstruction(i.keyword, i.args)
+	case "ENV":
+		return p.envInstruction(i.args)
+	case "LABEL":
+		return p.labelInstruction(i.args)
+	case "WORKDIR":
+		dir, err := p.single(i.args)
+		if err != nil {
+			return err
+		}
+		p.workDir = p.resolve(dir)
+		p.options.WorkingDir = p.workDir
+	case "USER":
+		user, err := p.single(i.args)
+		if err != nil {
+			return err
+		}
+		p.options.RunAs = user
+	case "ENTRYPOINT":
+		p.options.Entrypoint = command(i.args)
+	case "CMD":
+		p.options.Cmd = command(i.args)
+		p.cmd = true
+	case "EXPOSE":
+		ports, err := words(i.args, p.vars())
+		if err != nil {
+			return err
+		}
+		for _, port := range ports {
+			if !exposedPort.MatchString(port) {
+				return errors.New("wrong port " + port + " (expected port[/protocol])")
+			}
+		}
+		p.options.ExposedPorts = append(p.options.ExposedPorts, ports...)
+	case "RUN":
+		return errors.New("RUN is not supported: spectrum adds files on top of the base image without running commands, " +
+			"so move the command into the base image or into a step before the build")
+	default:
+		return errors.New("unsupported instruction (expected one of FROM, ARG, COPY, ADD, ENV, LABEL, WORKDIR, USER, ENTRYPOINT, CMD and EXPOSE)")
+	}
+	return nil
+}
+
+func (p *parser) fromInstruction(args string) error {
+	if p.from {
+		return errors.New("multi-stage builds are not supported")
+	}
+	w, err := words(args, p.vars())
+	if err != nil {
+		return err
+	}
+	if len(w) > 0 && strings.HasPrefix(w[0], "--") {
+		return errors.New("unsupported flag " + w[0])
+	}
+	if len(w) != 1 && (len(w) != 3 || !strings.EqualFold(w[1], "AS")) {
+		return errors.New("expected an image, optionally followed by AS and a name")
+	}
+	p.options.Base = w[0]
+	p.from = true
+	if p.getBaseEnv == nil {
+		return nil
+	}
+	env, err := p.getBaseEnv(w[0])
+	if err != nil {
+		return errors.Wrap(err, "could not read the environment of the base image")
+	}
+	for _, variable := range env {
+		if key, value, found := strings.Cut(variable, "="); found {
+			p.baseEnv[key] = value
+		}
+	}
+	return nil
+}
+
+func (p *parser) argInstruction(args string) error {
+	w, err := words(args, p.vars())
+	if err != nil {
+		return err
+	}
+	if len(w) == 0 {
+		return errors.New("missing argument name")
+	}
+	for _, arg := range w {
+		name, value, hasDefault := strings.Cut(arg, "=")
+		if name == "" {
+			return errors.New("missing argument name in " + arg)
+		}
+		if v, ok := p.buildArgs[name]; ok {
+			value, hasDefault = v, true
+		} else if v, ok := p.metaArgs[name]; ok && p.from && !hasDefault {
+			value, hasDefault = v, true
+		}
+		if !hasDefault {
+			continue
+		}
+		if p.from {
+			p.args[name] = value
+		} else {
+			p.metaArgs[name] = value
+		}
+	}
+	return nil
+}
+
+func (p *parser) envInstruction(args string) error {
+	pairs, err := p.pairs(args, true)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		p.env[pair[0]] = pair[1]
+		p.options.Env = append(p.options.Env, pair[0]+"="+pair[1])
+	}
+	return nil
+}
+
+func (p *parser) labelInstruction(args string) error {
+	pairs, err := p.pairs(args, false)
+	if err != nil {
+		return err
+	}
+	if p.options.Labels == nil {
+		p.options.Labels = make(map[string]string)
+	}
+	for _, pair := range pairs {
+		p.options.Labels[pair[0]] = pair[1]
+	}
+	return nil
+}
+
+// pairs parses the key=value pairs of the instruction, or a key followed by its value if allowed
+func (p *parser) pairs(args string, legacy bool) ([][2]string, error) {
+	w, err := words(args, p.vars())
+	if err != nil {
+		return nil, err
+	}
+	if len(w) == 0 {
+		return nil, errors.New("expected key=value pairs")
+	}
+	if legacy && !strings.Contains(w[0], "=") {
+		if len(w) < 2 {
+			return nil, errors.New("missing value of " + w[0])
+		}
+		return [][2]string{{w[0], strings.Join(w[1:], " ")}}, nil
+	}
+	pairs := make([][2]string, 0, len(w))
+	for _, pair := range w {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, errors.New("expected key=value, got " + pair)
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
+
+func (p *parser) copyInstruction(keyword string, args string) error {
+	var w []string
+	if exec, ok := execForm(args); ok {
+		for _, arg := range exec {
+			expanded, err := expand(arg, p.vars())
+			if err != nil {
+				return err
+			}
+			w = append(w, expanded)
+		}
+	} else {
+		var err error
+		if w, err = words(args, p.vars()); err != nil {
+			return err
+		}
+	}
+
+	template := builder.Mapping{}
+	recursive := true
+	template.Recursive = &recursive
+	from := ""
+	for len(w) > 0 && strings.HasPrefix(w[0], "--") {
+		flag, value, _ := strings.Cut(strings.TrimPrefix(w[0], "--"), "=")
+		switch {
+		case flag == "chown":
+			uid, gid, err := builder.ParseOwner(value)
+			if err != nil {
+				return errors.Wrap(err, "wrong --chown (user and group names are not supported)")
+			}
+			template.UID, template.GID = &uid, &gid
+		case flag == "chmod":
+			mode, err := parseMode(value)
+			if err != nil {
+				return err
+			}
+			template.Mode = &mode
+		case flag == "from" && keyword == "COPY":
+			from = value
+		case flag == "link":
+			// Layers are always independent of the previous ones
+		default:
+			return errors.New("unsupported flag " + w[0])
+		}
+		w = w[1:]
+	}
+	if len(w) < 2 {
+		return errors.New("expected at least one source and a destination")
+	}
+
+	sources, destination := w[:len(w)-1], p.resolve(w[len(w)-1])
+	if len(sources) > 1 && !strings.HasSuffix(destination, "/") {
+		return errors.New("the destination of multiple sources must end with /")
+	}
+	for _, source := range sources {
+		mapping := template
+		mapping.Destination = destination
+		switch {
+		case from != "":
+			mapping.Source = builder.ImageSourcePrefix + from + "!" + path.Join("/", source)
+		case strings.Contains(source, "://") || strings.HasPrefix(source, "git@"):
+			return errors.New("remote source " + source + " is not supported")
+		default:
+			local, err := p.local(source)
+			if err != nil {
+				return err
+			}
+			mapping.Source = local
+			if keyword == "ADD" && isArchive(source) {
+				mapping.Source = builder.ArchiveSourcePrefix + local
+			}
+		}
+		p.options.Mappings = append(p.options.Mappings, mapping)
+	}
+	return nil
+}
+
+// local returns the path of the source in the context directory
+func (p *parser) local(source string) (string, error) {
+	clean := strings.TrimPrefix(path.Clean(filepath.ToSlash(source)), "/")
+	if clean == "" {
+		clean = "."
+	}
+	if !fs.ValidPath(clean) {
+		return "", errors.New("source " + source + " is outside of the build context")
+	}
+	return filepath.Join(p.contextDir, filepath.FromSlash(clean)), nil
+}
+
+// resolve resolves the path in the image against the working directory, keeping any trailing slash
+func (p *parser) resolve(dst string) string {
+	if path.IsAbs(dst) {
+		return dst
+	}
+	resolved := path.Join(p.workDir, dst)
+	if strings.HasSuffix(dst, "/") || dst == "." {
+		resolved = strings.TrimSuffix(resolved, "/") + "/"
+	}
+	return resolved
+}
+
+// single parses a single word argument
+func (p *parser) single(args string) (string, error) {
+	w, err := words(args, p.vars())
+	if err != nil {
+		return "", err
+	}
+	if len(w) != 1 {
+		return "", errors.New("expected a single argument")
+	}
+	return w[0], nil
+}
+
+// command parses a command in the exec (JSON) form, or in the shell form run with /bin/sh -c
+func command(args string) []string {
+	if exec, ok := execForm(args); ok {
+		return exec
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+func execForm(args string) ([]string, bool) {
+	if !strings.HasPrefix(args, "[") {
+		return nil, false
+	}
+	var exec []string
+	if err := json.Unmarshal([]byte(args), &exec); err != nil {
+		return nil, false
+	}
+	if exec == nil {
+		exec = []string{}
+	}
+	return exec, true
+}
+
+func isArchive(source string) bool {
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(source, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMode(mode string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o7777 {
+		return 0, fmt.Errorf("wrong --chmod %q (expected an octal mode, e.g. 0644)", mode)
+	}
+	return fs.FileMode(m), nil
+}
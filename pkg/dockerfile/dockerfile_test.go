@@ -0,0 +1,146 @@
+package dockerfile
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDockerfile = `# syntax=docker/dockerfile:1
+ARG JDK_VERSION=8
+FROM adoptopenjdk/openjdk${JDK_VERSION}:slim AS runtime
+
+ARG JDK_VERSION
+ARG APP_DIR=/deployments
+ENV LANG=C.UTF-8 \
+    JAVA_OPTS="-Xmx1g -Dversion=${JDK_VERSION}"
+ENV APP_HOME $APP_DIR
+LABEL org.opencontainers.image.title="My app" version=1.0
+WORKDIR ${APP_HOME}
+COPY --chown=185:0 --chmod=0644 target/*-runner.jar app.jar
+COPY ["lib/", "lib/"]
+ADD dist.tar.gz static/
+ADD --chown=185 conf/app.properties /etc/app/
+COPY --from=local.dev/myorg/tools:1.0 usr/bin/helper /usr/local/bin/
+USER 185
+EXPOSE 8080 8443/tcp
+ENTRYPOINT ["java", "-jar", "app.jar"]
+`
+
+func TestParse(t *testing.T) {
+	options, err := Parse("Dockerfile", []byte(testDockerfile), "/src", map[string]string{"APP_DIR": "/opt/app"}, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "adoptopenjdk/openjdk8:slim", options.Base)
+	assert.Equal(t, []string{"LANG=C.UTF-8", "JAVA_OPTS=-Xmx1g -Dversion=8", "APP_HOME=/opt/app"}, options.Env)
+	assert.Equal(t, map[string]string{"org.opencontainers.image.title": "My app", "version": "1.0"}, options.Labels)
+	assert.Equal(t, "/opt/app", options.WorkingDir)
+	assert.Equal(t, "185", options.RunAs)
+	assert.Equal(t, []string{"8080", "8443/tcp"}, options.ExposedPorts)
+	assert.Equal(t, []string{"java", "-jar", "app.jar"}, options.Entrypoint)
+	assert.Equal(t, []string{}, options.Cmd)
+
+	uid, gid, root, mode, recursive := 185, 0, 185, fs.FileMode(0o644), true
+	assert.Equal(t, []builder.Mapping{
+		{Source: filepath.Join("/src", "target/*-runner.jar"), Destination: "/opt/app/app.jar", UID: &uid, GID: &gid, Mode: &mode, Recursive: &recursive},
+		{Source: filepath.Join("/src", "lib"), Destination: "/opt/app/lib/", Recursive: &recursive},
+		{Source: "archive://" + filepath.Join("/src", "dist.tar.gz"), Destination: "/opt/app/static/", Recursive: &recursive},
+		{Source: filepath.Join("/src", "conf/app.properties"), Destination: "/etc/app/", UID: &root, GID: &root, Recursive: &recursive},
+		{Source: "image://local.dev/myorg/tools:1.0!/usr/bin/helper", Destination: "/usr/local/bin/", Recursive: &recursive},
+	}, options.Mappings)
+}
+
+func TestParseCommands(t *testing.T) {
+	options, err := Parse("Dockerfile", []byte("FROM scratch\nCMD exec ./app --port $PORT\n"), ".", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "scratch", options.Base)
+	assert.Nil(t, options.Entrypoint)
+	assert.Equal(t, []string{"/bin/sh", "-c", "exec ./app --port $PORT"}, options.Cmd)
+
+	options, err = Parse("Dockerfile", []byte("FROM scratch\nENTRYPOINT [\"/app\"]\nCMD [\"--help\"]\n"), ".", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/app"}, options.Entrypoint)
+	assert.Equal(t, []string{"--help"}, options.Cmd)
+}
+
+func TestParseBaseEnv(t *testing.T) {
+	var bases []string
+	baseEnv := func(base string) ([]string, error) {
+		bases = append(bases, base)
+		return []string{"PATH=/usr/local/bin:/usr/bin", "HOME=/root", "LANG=C"}, nil
+	}
+	dockerfile := "ARG LANG=en\nFROM alpine\nARG HOME=/home/app\nENV PATH=/app/bin:$PATH LANG=${LANG}.UTF-8\nWORKDIR $HOME\n"
+	options, err := Parse("Dockerfile", []byte(dockerfile), ".", nil, baseEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alpine"}, bases)
+	assert.Equal(t, []string{"PATH=/app/bin:/usr/local/bin:/usr/bin", "LANG=C.UTF-8"}, options.Env)
+	// As in Docker, the environment of the base image takes precedence over the arguments
+	assert.Equal(t, "/root", options.WorkingDir)
+
+	options, err = Parse("Dockerfile", []byte("FROM alpine\nENV PATH=/app/bin:$PATH\n"), ".", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PATH=/app/bin:"}, options.Env)
+
+	_, err = Parse("Dockerfile", []byte("FROM alpine\nENV PATH=/app/bin:$PATH\n"), ".", nil, func(string) ([]string, error) {
+		return nil, errors.New("unauthorized")
+	})
+	assert.ErrorContains(t, err, "Dockerfile:1: FROM: could not read the environment of the base image: unauthorized")
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		err        string
+	}{
+		{name: "run", dockerfile: "FROM alpine\n\nRUN apk add curl\n", err: "Dockerfile:3: RUN: RUN is not supported"},
+		{name: "unsupported instruction", dockerfile: "FROM alpine\nHEALTHCHECK CMD true\n", err: "Dockerfile:2: HEALTHCHECK: unsupported instruction"},
+		{name: "missing from", dockerfile: "ARG VERSION=1\n", err: "Dockerfile: missing FROM instruction"},
+		{name: "instruction before from", dockerfile: "ENV A=b\nFROM alpine\n", err: "Dockerfile:1: ENV: only ARG instructions can precede FROM"},
+		{name: "multi-stage", dockerfile: "FROM maven AS build\nFROM alpine\n", err: "Dockerfile:2: FROM: multi-stage builds are not supported"},
+		{name: "platform", dockerfile: "FROM --platform=linux/amd64 alpine\n", err: "unsupported flag --platform=linux/amd64"},
+		{name: "chown names", dockerfile: "FROM alpine\nCOPY --chown=app:app . /app\n", err: "Dockerfile:2: COPY: wrong --chown (user and group names are not supported)"},
+		{name: "chmod", dockerfile: "FROM alpine\nCOPY --chmod=u+x app /app\n", err: `wrong --chmod "u+x"`},
+		{name: "remote add", dockerfile: "FROM alpine\nADD https://example.com/app.jar /app/\n", err: "remote source https://example.com/app.jar is not supported"},
+		{name: "add from", dockerfile: "FROM alpine\nADD --from=tools /bin/x /bin/\n", err: "unsupported flag --from=tools"},
+		{name: "outside context", dockerfile: "FROM alpine\nCOPY ../secret /app/\n", err: "source ../secret is outside of the build context"},
+		{name: "multiple sources", dockerfile: "FROM alpine\nCOPY a b /app\n", err: "the destination of multiple sources must end with /"},
+		{name: "missing destination", dockerfile: "FROM alpine\nCOPY app\n", err: "expected at least one source and a destination"},
+		{name: "wrong port", dockerfile: "FROM alpine\nEXPOSE http\n", err: "wrong port http"},
+		{name: "wrong label", dockerfile: "FROM alpine\nLABEL version\n", err: "expected key=value, got version"},
+		{name: "unterminated quote", dockerfile: "FROM alpine\nENV A=\"b\n", err: "unterminated double quote"},
+		{name: "continuation at the end", dockerfile: "FROM alpine\nCOPY a \\\n", err: "Dockerfile:2: COPY: expected at least one source and a destination"},
+		{name: "escape directive", dockerfile: "# escape=`\nFROM alpine\n", err: "unsupported escape character"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse("Dockerfile", []byte(test.dockerfile), ".", nil, nil)
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestWords(t *testing.T) {
+	vars := map[string]string{"NAME": "app", "EMPTY": ""}
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{input: `a  b	c`, expected: []string{"a", "b", "c"}},
+		{input: `"a b" 'c d'`, expected: []string{"a b", "c d"}},
+		{input: `$NAME ${NAME}.jar '$NAME' "$NAME"`, expected: []string{"app", "app.jar", "$NAME", "app"}},
+		{input: `${MISSING:-default} ${EMPTY:-default} ${EMPTY-default}`, expected: []string{"default", "default"}},
+		{input: `${NAME:+set} ${MISSING:+set}x`, expected: []string{"set", "x"}},
+		{input: `a\ b \$NAME "\"q\""`, expected: []string{"a b", "$NAME", `"q"`}},
+		{input: `key="a b"c $MISSING`, expected: []string{"key=a bc"}},
+	}
+	for _, test := range tests {
+		w, err := words(test.input, vars)
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, w, test.input)
+	}
+}
@@ -0,0 +1,153 @@
+package dockerfile
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// words splits the arguments into words separated by whitespace, removing the quotes and the escapes. Variables are
+// substituted, except within single quotes.
+func words(s string, vars map[string]string) ([]string, error) {
+	var result []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				result = append(result, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote in " + s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			inWord = true
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				switch {
+				case s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\$`, s[i+1]) >= 0:
+					i++
+					word.WriteByte(s[i])
+				case s[i] == '$':
+					value, n, err := variable(s[i:], vars)
+					if err != nil {
+						return nil, err
+					}
+					word.WriteString(value)
+					i += n - 1
+				default:
+					word.WriteByte(s[i])
+				}
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated double quote in " + s)
+			}
+		case c == '$':
+			value, n, err := variable(s[i:], vars)
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(value)
+			i += n - 1
+			inWord = inWord || value != ""
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		result = append(result, word.String())
+	}
+	return result, nil
+}
+
+// expand substitutes the variables of the string
+func expand(s string, vars map[string]string) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			result.WriteByte(s[i])
+			continue
+		}
+		value, n, err := variable(s[i:], vars)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(value)
+		i += n - 1
+	}
+	return result.String(), nil
+}
+
+// variable substitutes the variable at the start of the string, in the $NAME, ${NAME}, ${NAME:-default} or
+// ${NAME:+alternative} format, returning its value and the length of the reference
+func variable(s string, vars map[string]string) (string, int, error) {
+	if len(s) < 2 {
+		return s, len(s), nil
+	}
+	if s[1] != '{' {
+		n := 1
+		for n < len(s) && isNameChar(s[n], n == 1) {
+			n++
+		}
+		if n == 1 {
+			return "$", 1, nil
+		}
+		return vars[s[1:n]], n, nil
+	}
+
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return "", 0, errors.New("unterminated variable in " + s)
+	}
+	reference := s[2:end]
+	n := 0
+	for n < len(reference) && isNameChar(reference[n], n == 0) {
+		n++
+	}
+	name, modifier := reference[:n], reference[n:]
+	if name == "" {
+		return "", 0, errors.New("wrong variable ${" + reference + "}")
+	}
+	value, set := vars[name]
+	switch {
+	case modifier == "":
+		return value, end + 1, nil
+	case strings.HasPrefix(modifier, ":-") || strings.HasPrefix(modifier, "-"):
+		word, err := expand(strings.TrimLeft(modifier, ":")[1:], vars)
+		if err != nil {
+			return "", 0, err
+		}
+		if !set || (value == "" && modifier[0] == ':') {
+			value = word
+		}
+		return value, end + 1, nil
+	case strings.HasPrefix(modifier, ":+") || strings.HasPrefix(modifier, "+"):
+		word, err := expand(strings.TrimLeft(modifier, ":")[1:], vars)
+		if err != nil {
+			return "", 0, err
+		}
+		if set && (value != "" || modifier[0] != ':') {
+			return word, end + 1, nil
+		}
+		return "", end + 1, nil
+	default:
+		return "", 0, errors.New("unsupported variable substitution ${" + reference + "}")
+	}
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics collects the metrics of the builds it's notified of, to be used as the events of the build options
+type Metrics struct {
+	builder.NopEvents
+
+	registry      *prometheus.Registry
+	builds        *prometheus.CounterVec
+	packagedBytes prometheus.Counter
+	blobBytes     *prometheus.CounterVec
+	cacheRequests *prometheus.CounterVec
+	pullDuration  *prometheus.HistogramVec
+	pushDuration  *prometheus.HistogramVec
+}
+
+// New creates the build metrics, registered together with the Go and process metrics in their own registry
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		builds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spectrum_builds_total",
+			Help: "Number of builds, by outcome (success or failure).",
+		}, []string{"outcome"}),
+		packagedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spectrum_packaged_bytes_total",
+			Help: "Compressed size of the layers packaged by the builds.",
+		}),
+		blobBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spectrum_blob_bytes_total",
+			Help: "Compressed size of the layers pushed to the registries, by result (uploaded, or skipped as already existing).",
+		}, []string{"result"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spectrum_blob_cache_requests_total",
+			Help: "Number of layers checked against the registries, by result (hit if already existing, miss if uploaded).",
+		}, []string{"result"}),
+		pullDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spectrum_pull_duration_seconds",
+			Help:    "Time spent pulling the base images, by registry.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"registry"}),
+		pushDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spectrum_push_duration_seconds",
+			Help:    "Time spent pushing the images, by registry.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"registry"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.builds, m.packagedBytes, m.blobBytes, m.cacheRequests, m.pullDuration, m.pushDuration,
+	)
+	return m
+}
+
+// Handler returns the handler exposing the metrics in the Prometheus format, e.g. on /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) OnBuildComplete(result *builder.BuildResult, err error) {
+	if err != nil {
+		m.builds.WithLabelValues("failure").Inc()
+	} else {
+		m.builds.WithLabelValues("success").Inc()
+	}
+
+	for _, layer := range result.Layers {
+		if !layer.Reused {
+			m.packagedBytes.Add(float64(layer.Size))
+		}
+	}
+	if result.PullDuration > 0 {
+		m.pullDuration.WithLabelValues(imageRegistry(result.Base)).Observe(result.PullDuration.Seconds())
+	}
+	if err == nil && result.Plan == nil {
+		pushRegistry := result.Registry
+		if pushRegistry == "" {
+			pushRegistry = "none"
+		}
+		m.pushDuration.WithLabelValues(pushRegistry).Observe(result.PushDuration.Seconds())
+		m.blobBytes.WithLabelValues("uploaded").Add(float64(result.BytesUploaded))
+		m.blobBytes.WithLabelValues("skipped").Add(float64(result.BytesSkipped))
+		m.cacheRequests.WithLabelValues("miss").Add(float64(result.BlobsUploaded))
+		m.cacheRequests.WithLabelValues("hit").Add(float64(result.BlobsSkipped))
+	}
+}
+
+// imageRegistry returns the registry of the image, or "none" for scratch images
+func imageRegistry(image string) string {
+	if image == "" || image == "scratch" {
+		return "none"
+	}
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "none"
+	}
+	return ref.Context().RegistryStr()
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	base, err := random.Image(1024, 2)
+	assert.NoError(t, err)
+	ref, err := name.ParseReference(host + "/base:1.0")
+	assert.NoError(t, err)
+	assert.NoError(t, remote.Write(ref, base))
+
+	tmpDir, err := os.MkdirTemp("", "spectrum-metrics-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.jar"), []byte("app"), 0o644))
+
+	m := New()
+	options := builder.Options{
+		Base:         host + "/base:1.0",
+		Target:       host + "/myapp:1.0",
+		PullInsecure: true,
+		PushInsecure: true,
+		Events:       m,
+	}
+	_, err = builder.Build(options, tmpDir+":/deployments")
+	assert.NoError(t, err)
+	options.Base = host + "/missing:1.0"
+	_, err = builder.Build(options, tmpDir+":/deployments")
+	assert.Error(t, err)
+
+	metricsServer := httptest.NewServer(m.Handler())
+	defer metricsServer.Close()
+	resp, err := http.Get(metricsServer.URL + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	metrics := string(body)
+
+	for _, line := range []string{
+		`spectrum_builds_total{outcome="success"} 1`,
+		`spectrum_builds_total{outcome="failure"} 1`,
+		`spectrum_blob_cache_requests_total{result="hit"} 2`,
+		`spectrum_blob_cache_requests_total{result="miss"} 1`,
+		`spectrum_pull_duration_seconds_count{registry="` + host + `"} 1`,
+		`spectrum_push_duration_seconds_count{registry="` + host + `"} 1`,
+	} {
+		assert.Contains(t, metrics, line+"\n")
+	}
+	assert.Regexp(t, `spectrum_packaged_bytes_total [1-9]`, metrics)
+	assert.Regexp(t, `spectrum_blob_bytes_total\{result="uploaded"\} [1-9]`, metrics)
+	assert.Regexp(t, `spectrum_blob_bytes_total\{result="skipped"\} [1-9]`, metrics)
+}
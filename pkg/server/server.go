@@ -0,0 +1,437 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/container-tools/spectrum/pkg/metrics"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxUploadSize is the default limit of the size of a build request, sources included
+	DefaultMaxUploadSize = 512 << 20
+
+	eventStreamType = "text/event-stream"
+	progressPeriod  = 250 * time.Millisecond
+)
+
+// Server builds images from the sources uploaded through its HTTP API:
+//
+//   - POST /build accepts a multipart/form-data request with a "spec" part containing the JSON build spec, a
+//     "context" part containing a tar stream (optionally compressed with gzip) of the sources and "file" parts
+//     containing single sources, named after their file name. A tar stream can also be sent as the request body,
+//     with the build spec in the "spec" query parameter, or a JSON build spec without any sources. The result of the
+//     build is returned as JSON, or streamed as server-sent events together with the progress of the build when the
+//     request accepts text/event-stream. Builds are canceled when the client goes away.
+//   - GET /metrics exposes the build metrics in the Prometheus format.
+//   - GET /healthz returns 200 while the server is running.
+type Server struct {
+	// Options are the options shared by all the builds, e.g. the registry credentials, on top of which the build
+	// spec of each request is applied
+	Options builder.Options
+	// MaxUploadSize limits the size of the build requests, DefaultMaxUploadSize if zero. It also limits the size of
+	// the sources once extracted.
+	MaxUploadSize int64
+	// AllowedTargets limits the targets to the repositories under one of the prefixes, either a registry, e.g.
+	// registry.example.com, or a repository, e.g. registry.example.com/team. Any target is allowed if empty.
+	AllowedTargets []string
+	// AllowInsecure allows the build requests to pull from and push to insecure registries
+	AllowInsecure bool
+	// Logger logs the requests and the builds, discarding the logs if nil
+	Logger *slog.Logger
+
+	metrics *metrics.Metrics
+}
+
+// New creates a server building images with the given options
+func New(options builder.Options, logger *slog.Logger) *Server {
+	return &Server{
+		Options: options,
+		Logger:  logger,
+		metrics: metrics.New(),
+	}
+}
+
+// Validate checks the configuration of the server, e.g. that the allowed targets are valid
+func (s *Server) Validate() error {
+	for _, allowed := range s.AllowedTargets {
+		if _, err := allowedPrefix(allowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns the handler serving the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/build", s.build)
+	mux.Handle("/metrics", s.metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// requestError is an error caused by a wrong build request
+type requestError struct {
+	error
+}
+
+func (e requestError) Unwrap() error {
+	return e.error
+}
+
+func badRequest(err error) error {
+	return requestError{err}
+}
+
+func (s *Server) build(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method "+r.Method+" not allowed"))
+		return
+	}
+
+	sourcesDir, err := os.MkdirTemp("", "spectrum-serve-*")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(sourcesDir)
+
+	maxUploadSize := s.MaxUploadSize
+	if maxUploadSize == 0 {
+		maxUploadSize = DefaultMaxUploadSize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	options, err := s.readRequest(r, sourcesDir, newSourcesLimit(maxUploadSize))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError) || errors.As(err, &sourcesTooLargeError{}):
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+		case errors.As(err, &requestError{}):
+			writeError(w, http.StatusBadRequest, err)
+		default:
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	logger := s.logger().With("target", options.Target)
+	options.Logger = logger
+	logger.Info("Build requested", "remote", r.RemoteAddr)
+
+	var stream *eventStream
+	if acceptsEventStream(r) {
+		if flusher, ok := w.(http.Flusher); ok {
+			stream = newEventStream(w, flusher)
+		}
+	}
+	if stream != nil {
+		options.Events = builder.MultiEvents(s.metrics, stream)
+	} else {
+		options.Events = s.metrics
+	}
+
+	result, err := builder.BuildContext(r.Context(), options)
+	if err != nil {
+		logger.Error("Build failed", "error", err)
+	}
+	switch {
+	case stream != nil && err != nil:
+		stream.send("error", errorResponse{Error: err.Error()})
+	case stream != nil:
+		stream.send("result", result)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// readRequest reads the build spec and extracts the sources into the given directory, up to the limit
+func (s *Server) readRequest(r *http.Request, sourcesDir string, limit *sourcesLimit) (builder.Options, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil && r.Header.Get("Content-Type") != "" {
+		return s.Options, badRequest(errors.Wrap(err, "wrong content type"))
+	}
+
+	var spec *BuildSpec
+	sources := false
+	switch mediaType {
+	case "multipart/form-data":
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return s.Options, badRequest(err)
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return s.Options, badRequest(err)
+			}
+			switch part.FormName() {
+			case "spec":
+				if spec, err = decodeSpec(part); err != nil {
+					return s.Options, err
+				}
+			case "context":
+				if err := extractSources(part, sourcesDir, limit); err != nil {
+					return s.Options, badRequest(errors.Wrap(err, "wrong context"))
+				}
+				sources = true
+			case "file":
+				if err := writeFilePart(part, sourcesDir, limit); err != nil {
+					return s.Options, err
+				}
+				sources = true
+			default:
+				return s.Options, badRequest(errors.New("unexpected part " + part.FormName()))
+			}
+		}
+	case "application/json":
+		if spec, err = decodeSpec(r.Body); err != nil {
+			return s.Options, err
+		}
+	default:
+		if spec, err = decodeSpec(strings.NewReader(r.URL.Query().Get("spec"))); err != nil {
+			return s.Options, err
+		}
+		if err := extractSources(r.Body, sourcesDir, limit); err != nil {
+			return s.Options, badRequest(errors.Wrap(err, "wrong context"))
+		}
+		sources = true
+	}
+	if spec == nil {
+		return s.Options, badRequest(errors.New("missing build spec"))
+	}
+
+	if !sources {
+		sourcesDir = ""
+	}
+	options, err := spec.apply(s.Options, sourcesDir)
+	if err != nil {
+		return options, badRequest(errors.Wrap(err, "wrong build spec"))
+	}
+	if (options.PullInsecure || options.PushInsecure) && !s.AllowInsecure {
+		return options, badRequest(errors.New("insecure registries aren't allowed by the server"))
+	}
+	if err := s.checkTarget(options.Target); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// checkTarget fails if the target isn't in one of the allowed repositories
+func (s *Server) checkTarget(target string) error {
+	if len(s.AllowedTargets) == 0 {
+		return nil
+	}
+	ref, err := name.ParseReference(target)
+	if err != nil {
+		return badRequest(errors.Wrap(err, "wrong target"))
+	}
+	repository := ref.Context().Name()
+	for _, allowed := range s.AllowedTargets {
+		prefix, err := allowedPrefix(allowed)
+		if err != nil {
+			return err
+		}
+		if repository == prefix || strings.HasPrefix(repository, prefix+"/") {
+			return nil
+		}
+	}
+	return badRequest(errors.New("target " + target + " isn't allowed by the server"))
+}
+
+// allowedPrefix normalizes an allowed registry or repository, e.g. docker.io/myorg as index.docker.io/myorg
+func allowedPrefix(allowed string) (string, error) {
+	if !strings.Contains(allowed, "/") {
+		registry, err := name.NewRegistry(allowed)
+		if err != nil {
+			return "", errors.Wrapf(err, "wrong allowed target %s", allowed)
+		}
+		return registry.Name(), nil
+	}
+	// Parsed as the parent of a repository, so that docker.io/myorg isn't made docker.io/library/myorg
+	repository, err := name.NewRepository(strings.TrimSuffix(allowed, "/") + "/image")
+	if err != nil {
+		return "", errors.Wrapf(err, "wrong allowed target %s", allowed)
+	}
+	return path.Dir(repository.Name()), nil
+}
+
+func decodeSpec(r io.Reader) (*BuildSpec, error) {
+	spec := BuildSpec{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, badRequest(errors.Wrap(err, "wrong build spec"))
+	}
+	return &spec, nil
+}
+
+// writeFilePart writes a single source named after the file name of the part. The name isn't read with
+// part.FileName, since it drops the directories.
+func writeFilePart(part *multipart.Part, sourcesDir string, limit *sourcesLimit) error {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return badRequest(errors.Wrap(err, "wrong file part"))
+	}
+	name, err := sourcePath(params["filename"])
+	if err != nil || name == "." {
+		return badRequest(errors.New("wrong file part: missing or illegal file name " + params["filename"]))
+	}
+	if err := checkParents(sourcesDir, name); err != nil {
+		return badRequest(err)
+	}
+	target := filepath.Join(sourcesDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := limit.take(tarBlockSize); err != nil {
+		return err
+	}
+	if err := writeSource(target, part, 0o644, limit); err != nil {
+		if os.IsExist(err) {
+			return badRequest(errors.New("duplicate file " + path.Clean(name)))
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == eventStreamType {
+			return true
+		}
+	}
+	return false
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// eventStream sends the build events as server-sent events. The progress of each layer is sent at most every
+// progressPeriod, besides its completion.
+type eventStream struct {
+	mu       sync.Mutex
+	w        io.Writer
+	flusher  http.Flusher
+	progress map[string]time.Time
+}
+
+type pullEvent struct {
+	Image string `json:"image"`
+}
+
+type layerEvent struct {
+	Source string  `json:"source"`
+	Digest v1.Hash `json:"digest"`
+	Size   int64   `json:"size"`
+}
+
+type uploadEvent struct {
+	Complete int64 `json:"complete"`
+	Total    int64 `json:"total"`
+}
+
+type pushEvent struct {
+	Target string  `json:"target"`
+	Digest v1.Hash `json:"digest"`
+}
+
+func newEventStream(w http.ResponseWriter, flusher http.Flusher) *eventStream {
+	w.Header().Set("Content-Type", eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &eventStream{w: w, flusher: flusher, progress: make(map[string]time.Time)}
+}
+
+// send writes an event, ignoring the errors: the build is canceled anyway when the client goes away
+func (e *eventStream) send(event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := io.WriteString(e.w, "event: "+event+"\ndata: "+string(payload)+"\n\n"); err == nil {
+		e.flusher.Flush()
+	}
+}
+
+func (e *eventStream) OnPullStart(image string) {
+	e.send("pull", pullEvent{Image: image})
+}
+
+func (e *eventStream) OnLayerPackaged(source string, digest v1.Hash, size int64) {
+	e.send("layer", layerEvent{Source: source, Digest: digest, Size: size})
+}
+
+func (e *eventStream) OnUploadProgress(complete, total int64) {
+	if e.throttle("upload", complete == total) {
+		e.send("upload", uploadEvent{Complete: complete, Total: total})
+	}
+}
+
+func (e *eventStream) OnLayerProgress(progress builder.LayerProgress) {
+	if e.throttle(progress.Operation+progress.Digest.String(), progress.Complete == progress.Total) {
+		e.send("progress", progress)
+	}
+}
+
+func (e *eventStream) OnPushComplete(target string, digest v1.Hash) {
+	e.send("push", pushEvent{Target: target, Digest: digest})
+}
+
+func (e *eventStream) OnBuildComplete(result *builder.BuildResult, err error) {}
+
+// throttle returns whether the progress with the given key should be sent
+func (e *eventStream) throttle(key string, done bool) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if !done && now.Sub(e.progress[key]) < progressPeriod {
+		return false
+	}
+	e.progress[key] = now
+	return true
+}
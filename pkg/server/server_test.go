@@ -0,0 +1,305 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntry struct {
+	name     string
+	content  string
+	linkname string
+}
+
+func writeTestTar(t *testing.T, entries ...testEntry) []byte {
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		if entry.linkname != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0o777, Typeflag: tar.TypeSymlink, Linkname: entry.linkname}
+		}
+		assert.NoError(t, writer.WriteHeader(header))
+		_, err := writer.Write([]byte(entry.content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func multipartRequest(t *testing.T, url string, spec string, context []byte, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	assert.NoError(t, writer.WriteField("spec", spec))
+	if context != nil {
+		part, err := writer.CreateFormFile("context", "context.tar")
+		assert.NoError(t, err)
+		_, err = part.Write(context)
+		assert.NoError(t, err)
+	}
+	for name, content := range files {
+		part, err := writer.CreateFormFile("file", name)
+		assert.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	request, err := http.NewRequest(http.MethodPost, url+"/build", &body)
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func testServers(t *testing.T, configure ...func(*Server)) (string, *httptest.Server) {
+	registryServer := httptest.NewServer(registry.New())
+	t.Cleanup(registryServer.Close)
+	srv := New(builder.Options{}, nil)
+	srv.AllowInsecure = true
+	for _, c := range configure {
+		c(srv)
+	}
+	server := httptest.NewServer(srv.Handler())
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(registryServer.URL, "http://"), server
+}
+
+func testSpec(target string, mappings ...MappingSpec) string {
+	spec, _ := json.Marshal(BuildSpec{Target: target, PushInsecure: true, Mappings: mappings})
+	return string(spec)
+}
+
+func TestBuild(t *testing.T) {
+	registryHost, server := testServers(t)
+	target := registryHost + "/app:1.0"
+
+	context := writeTestTar(t,
+		testEntry{name: "dist/index.html", content: "index"},
+		testEntry{name: "dist/js/app.js", content: "app"},
+	)
+	request := multipartRequest(t, server.URL, testSpec(target,
+		MappingSpec{Source: "dist", Destination: "/app/"},
+		MappingSpec{Source: "./conf/app.conf", Destination: "/etc/app.conf"},
+	), context, map[string]string{"conf/app.conf": "conf"})
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	result := builder.BuildResult{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+	assert.Equal(t, target, result.Target)
+	assert.Len(t, result.Layers, 2)
+
+	ref, err := name.ParseReference(target)
+	assert.NoError(t, err)
+	descriptor, err := remote.Head(ref)
+	assert.NoError(t, err)
+	assert.Equal(t, result.Digest, descriptor.Digest.String())
+
+	response, err = http.Get(server.URL + "/metrics")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	metrics, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(metrics), `spectrum_builds_total{outcome="success"} 1`)
+}
+
+func TestBuildEventStream(t *testing.T) {
+	registryHost, server := testServers(t)
+	target := registryHost + "/app:1.0"
+
+	context := writeTestTar(t, testEntry{name: "index.html", content: "index"})
+	request, err := http.NewRequest(http.MethodPost,
+		server.URL+"/build?spec="+url.QueryEscape(testSpec(target, MappingSpec{Source: ".", Destination: "/app/"})),
+		bytes.NewReader(context))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/x-tar")
+	request.Header.Set("Accept", "text/event-stream")
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	var events []string
+	var data string
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if event, ok := strings.CutPrefix(line, "event: "); ok {
+			events = append(events, event)
+		} else if d, ok := strings.CutPrefix(line, "data: "); ok {
+			data = d
+		}
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Contains(t, events, "pull")
+	assert.Contains(t, events, "layer")
+	assert.Contains(t, events, "push")
+	assert.Equal(t, "result", events[len(events)-1])
+
+	result := builder.BuildResult{}
+	assert.NoError(t, json.Unmarshal([]byte(data), &result))
+	assert.Equal(t, target, result.Target)
+	assert.NotEmpty(t, result.Digest)
+}
+
+func TestBadRequests(t *testing.T) {
+	registryHost, server := testServers(t)
+	target := registryHost + "/app:1.0"
+
+	tests := []struct {
+		name    string
+		spec    string
+		context []byte
+		files   map[string]string
+	}{
+		{name: "wrong spec", spec: `{"target": 1}`},
+		{name: "unknown field", spec: `{"target": "app", "source": "."}`},
+		{name: "missing target", spec: testSpec("", MappingSpec{Source: ".", Destination: "/app/"})},
+		{name: "file target", spec: testSpec("oci:/tmp/layout", MappingSpec{Source: ".", Destination: "/app/"})},
+		{name: "missing content", spec: testSpec(target)},
+		{name: "host source", spec: testSpec(target, MappingSpec{Source: "archive:///etc/passwd.tar", Destination: "/app/"}),
+			files: map[string]string{"index.html": "index"}},
+		{name: "outside source", spec: testSpec(target, MappingSpec{Source: "../etc", Destination: "/app/"}),
+			files: map[string]string{"index.html": "index"}},
+		{name: "illegal file name", spec: testSpec(target, MappingSpec{Source: ".", Destination: "/app/"}),
+			files: map[string]string{"../index.html": "index"}},
+		{name: "symbolic link outside", spec: testSpec(target, MappingSpec{Source: ".", Destination: "/app/"}),
+			context: writeTestTar(t, testEntry{name: "passwd", linkname: "../../../etc/passwd"})},
+		{name: "absolute symbolic link", spec: testSpec(target, MappingSpec{Source: ".", Destination: "/app/"}),
+			context: writeTestTar(t, testEntry{name: "passwd", linkname: "/etc/passwd"})},
+		{name: "symbolic link through link", spec: testSpec(target, MappingSpec{Source: ".", Destination: "/app/"}),
+			context: writeTestTar(t,
+				testEntry{name: "a/b/up", linkname: ".."},
+				testEntry{name: "a/b/root", linkname: "up/../.."},
+			)},
+		{name: "file through link", spec: testSpec(target, MappingSpec{Source: ".", Destination: "/app/"}),
+			context: writeTestTar(t,
+				testEntry{name: "tmp", linkname: "."},
+				testEntry{name: "tmp/index.html", content: "index"},
+			)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(multipartRequest(t, server.URL, test.spec, test.context, test.files))
+			assert.NoError(t, err)
+			defer response.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+			body := errorResponse{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(&body))
+			assert.NotEmpty(t, body.Error)
+		})
+	}
+}
+
+func TestInsecureNotAllowed(t *testing.T) {
+	registryHost, server := testServers(t, func(s *Server) {
+		s.AllowInsecure = false
+	})
+
+	request := multipartRequest(t, server.URL, testSpec(registryHost+"/app:1.0", MappingSpec{Source: ".", Destination: "/app/"}),
+		nil, map[string]string{"index.html": "index"})
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestAllowedTargets(t *testing.T) {
+	registryHost, server := testServers(t, func(s *Server) {
+		s.AllowedTargets = []string{"registry.example.com", "localhost/team/"}
+	})
+	srv := &Server{AllowedTargets: []string{"registry.example.com", "localhost/team/", "docker.io/myorg"}}
+	assert.NoError(t, srv.Validate())
+
+	tests := []struct {
+		target  string
+		allowed bool
+	}{
+		{target: "registry.example.com/app:1.0", allowed: true},
+		{target: "registry.example.com/team/app@sha256:" + strings.Repeat("0", 64), allowed: true},
+		{target: "localhost/team/app", allowed: true},
+		{target: "localhost/team", allowed: true},
+		{target: "localhost/teams/app", allowed: false},
+		{target: "localhost/app", allowed: false},
+		{target: "myorg/app", allowed: true},
+		{target: "index.docker.io/myorg/app", allowed: true},
+		{target: "otherorg/app", allowed: false},
+		{target: "registry.example.com.evil/app", allowed: false},
+		{target: registryHost + "/app:1.0", allowed: false},
+	}
+	for _, test := range tests {
+		err := srv.checkTarget(test.target)
+		if test.allowed {
+			assert.NoError(t, err, test.target)
+		} else {
+			assert.Error(t, err, test.target)
+		}
+	}
+
+	request := multipartRequest(t, server.URL, testSpec(registryHost+"/app:1.0", MappingSpec{Source: ".", Destination: "/app/"}),
+		nil, map[string]string{"index.html": "index"})
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	assert.Error(t, (&Server{AllowedTargets: []string{"Wrong Registry"}}).Validate())
+}
+
+func TestExtractedSourcesTooLarge(t *testing.T) {
+	registryHost, server := testServers(t, func(s *Server) {
+		s.MaxUploadSize = 64 << 10
+	})
+
+	// Compressed, the sources are much smaller than the limit
+	var context bytes.Buffer
+	writer := gzip.NewWriter(&context)
+	_, err := writer.Write(writeTestTar(t, testEntry{name: "zeros", content: strings.Repeat("\x00", 1<<20)}))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.Less(t, context.Len(), 64<<10)
+
+	request := multipartRequest(t, server.URL, testSpec(registryHost+"/app:1.0", MappingSpec{Source: ".", Destination: "/app/"}),
+		context.Bytes(), nil)
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+}
+
+func TestExtractSources(t *testing.T) {
+	dir, err := os.MkdirTemp("", "spectrum-serve-test-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, extractSources(bytes.NewReader(writeTestTar(t,
+		testEntry{name: "./dist/index.html", content: "index"},
+		testEntry{name: "dist/current", linkname: "index.html"},
+		testEntry{name: "lib/index.html", linkname: "../dist/index.html"},
+	)), dir, newSourcesLimit(DefaultMaxUploadSize)))
+
+	for _, name := range []string{"dist/index.html", "dist/current", "lib/index.html"} {
+		content, err := os.ReadFile(dir + "/" + name)
+		assert.NoError(t, err)
+		assert.Equal(t, "index", string(content))
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// tarBlockSize is the size of a tar block, that each extracted entry is counted as at least
+const tarBlockSize = 512
+
+// sourcesTooLargeError is returned when the extracted sources exceed the size limit
+type sourcesTooLargeError struct {
+	limit int64
+}
+
+func (e sourcesTooLargeError) Error() string {
+	return fmt.Sprintf("sources too large: more than %d bytes once extracted", e.limit)
+}
+
+// sourcesLimit limits the total size of the sources extracted for a request, which can be much larger than the
+// request itself when compressed
+type sourcesLimit struct {
+	limit     int64
+	remaining int64
+}
+
+func newSourcesLimit(limit int64) *sourcesLimit {
+	return &sourcesLimit{limit: limit, remaining: limit}
+}
+
+// take counts the bytes against the limit, failing once it's exceeded
+func (l *sourcesLimit) take(n int64) error {
+	l.remaining -= n
+	if l.remaining < 0 {
+		return sourcesTooLargeError{limit: l.limit}
+	}
+	return nil
+}
+
+// extractSources extracts a tar stream, optionally compressed with gzip, into the directory. Symbolic links must
+// stay within the directory, so that the builds can't read the files of the server through them. The extracted
+// entries are counted against the limit.
+func extractSources(r io.Reader, dir string, limit *sourcesLimit) error {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var stream io.Reader = reader
+	if bytes.Equal(magic, gzipMagic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		stream = gzipReader
+	}
+
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return checkLinks(dir)
+		} else if err != nil {
+			return err
+		}
+
+		name, err := sourcePath(header.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		if err := limit.take(tarBlockSize); err != nil {
+			return err
+		}
+		if err := checkParents(dir, name); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeSource(target, tarReader, fs.FileMode(header.Mode).Perm(), limit); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := header.Linkname
+			if !path.IsAbs(link) {
+				link = path.Join(path.Dir(name), link)
+			}
+			if _, err := sourcePath(link); err != nil || path.IsAbs(header.Linkname) {
+				return fmt.Errorf("illegal source %s: symbolic link to %s is outside of the sources", header.Name, header.Linkname)
+			}
+			if err := os.Symlink(filepath.FromSlash(header.Linkname), target); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported source %s (type %q)", header.Name, header.Typeflag)
+		}
+	}
+}
+
+// writeSource writes the content into the file, failing if it already exists, e.g. as a symbolic link, or if it
+// exceeds the limit
+func writeSource(target string, content io.Reader, mode fs.FileMode, limit *sourcesLimit) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode|0o600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, io.LimitReader(content, limit.remaining+1))
+	if err == nil {
+		err = limit.take(n)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkParents fails if any of the parent directories of the source is a symbolic link, that could make it be
+// written outside of the sources
+func checkParents(dir string, name string) error {
+	parent := dir
+	for _, element := range strings.Split(path.Dir(name), "/") {
+		if element == "." {
+			break
+		}
+		parent = filepath.Join(parent, element)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return errors.New("illegal source " + name + ": parent directory is a symbolic link")
+		}
+	}
+	return nil
+}
+
+// checkLinks fails if any of the extracted symbolic links resolves outside of the sources, e.g. by going up from
+// another link
+func checkLinks(dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		resolved, err := filepath.EvalSymlinks(p)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			name, _ := filepath.Rel(dir, p)
+			return errors.New("illegal source " + filepath.ToSlash(name) + ": symbolic link resolves outside of the sources")
+		}
+		return nil
+	})
+}
+
+// sourcePath validates the name of an uploaded source and returns it relative to the sources root
+func sourcePath(name string) (string, error) {
+	p := path.Clean(strings.TrimLeft(name, "/"))
+	if !fs.ValidPath(p) {
+		return "", errors.New("illegal source " + name + ": path is outside of the sources")
+	}
+	return p, nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/container-tools/spectrum/pkg/builder"
+	"github.com/pkg/errors"
+)
+
+// BuildSpec describes the image to build from the uploaded sources
+type BuildSpec struct {
+	Base            string            `json:"base"`
+	Target          string            `json:"target"`
+	PullInsecure    bool              `json:"pullInsecure"`
+	PushInsecure    bool              `json:"pushInsecure"`
+	Annotations     map[string]string `json:"annotations"`
+	Mappings        []MappingSpec     `json:"mappings"`
+	Files           []FileSpec        `json:"files"`
+	Remove          []string          `json:"remove"`
+	ClearEntrypoint bool              `json:"clearEntrypoint"`
+	RunAs           string            `json:"runAs"`
+	Squash          bool              `json:"squash"`
+	SquashAdded     bool              `json:"squashAdded"`
+	Report          bool              `json:"report"`
+}
+
+// MappingSpec describes some uploaded content, or some content of another image, to be added to the image
+type MappingSpec struct {
+	// Source is a path relative to the uploaded sources, or an image:// source
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Chown       string   `json:"chown"`
+	Chmod       string   `json:"chmod"`
+	Exclude     []string `json:"exclude"`
+	Recursive   *bool    `json:"recursive"`
+}
+
+// FileSpec describes a file to be created in the image with the given content
+type FileSpec struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	Mode    string `json:"mode"`
+}
+
+// apply sets the spec on top of the server options, reading the mapped sources from the given directory
+func (s BuildSpec) apply(options builder.Options, sourcesDir string) (builder.Options, error) {
+	if s.Target == "" {
+		return options, errors.New("missing target")
+	}
+	for _, scheme := range []string{builder.OCILayoutScheme, builder.DockerArchiveScheme, builder.DaemonScheme} {
+		// Writing to the file system or to the daemon of the server isn't allowed
+		if strings.HasPrefix(s.Target, scheme+":") {
+			return options, errors.New("unsupported target " + s.Target + " (expected an image of a registry)")
+		}
+	}
+	if s.Squash && s.SquashAdded {
+		return options, errors.New("only one of squash and squashAdded can be specified")
+	}
+	if len(s.Mappings) == 0 && len(s.Files) == 0 && len(s.Remove) == 0 {
+		return options, errors.New("at least one of mappings, files and remove is required")
+	}
+
+	options.Base = s.Base
+	options.Target = s.Target
+	options.PullInsecure = s.PullInsecure
+	options.PushInsecure = s.PushInsecure
+	options.Annotations = s.Annotations
+	options.Remove = s.Remove
+	options.ClearEntrypoint = s.ClearEntrypoint
+	options.RunAs = s.RunAs
+	options.Squash = s.Squash
+	options.SquashAdded = s.SquashAdded
+	options.Report = s.Report
+	options.Mappings = nil
+	options.Files = nil
+
+	var sources fs.FS
+	if sourcesDir != "" {
+		sources = os.DirFS(sourcesDir)
+	}
+	for i, m := range s.Mappings {
+		mapping, err := m.mapping(sources)
+		if err != nil {
+			return options, errors.Wrapf(err, "wrong mapping %d", i)
+		}
+		options.Mappings = append(options.Mappings, mapping)
+	}
+	for i, f := range s.Files {
+		file := builder.File{Path: f.Path, Content: []byte(f.Content)}
+		if f.Mode != "" {
+			mode, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil {
+				return options, errors.Wrapf(err, "wrong mode of file %d", i)
+			}
+			fileMode := fs.FileMode(mode)
+			file.Mode = &fileMode
+		}
+		options.Files = append(options.Files, file)
+	}
+	return options, nil
+}
+
+// mapping converts the spec into a mapping. Only the uploaded sources and other images can be copied,
+// not the files of the server.
+func (m MappingSpec) mapping(sources fs.FS) (builder.Mapping, error) {
+	mapping := builder.Mapping{
+		Source:      m.Source,
+		Destination: m.Destination,
+		Exclude:     m.Exclude,
+		Recursive:   m.Recursive,
+	}
+	if m.Chown != "" {
+		uid, gid, err := builder.ParseOwner(m.Chown)
+		if err != nil {
+			return builder.Mapping{}, err
+		}
+		mapping.UID = &uid
+		mapping.GID = &gid
+	}
+	if m.Chmod != "" {
+		mode, err := strconv.ParseUint(m.Chmod, 8, 32)
+		if err != nil {
+			return builder.Mapping{}, errors.Wrapf(err, "wrong chmod %s", m.Chmod)
+		}
+		fileMode := fs.FileMode(mode)
+		mapping.Mode = &fileMode
+	}
+
+	switch {
+	case strings.HasPrefix(m.Source, builder.ImageSourcePrefix):
+		return mapping, nil
+	case strings.Contains(m.Source, "://") || strings.HasPrefix(m.Source, builder.LayerSourcePrefix) || m.Source == builder.StdinSource:
+		return builder.Mapping{}, errors.New("unsupported source " + m.Source + " (expected a path of the uploaded sources or an image:// source)")
+	case sources == nil:
+		return builder.Mapping{}, errors.New("no sources uploaded for " + m.Source)
+	}
+	mapping.Source = path.Clean(strings.TrimPrefix(m.Source, "./"))
+	if !fs.ValidPath(mapping.Source) {
+		return builder.Mapping{}, errors.New("illegal source " + m.Source + " (expected a path relative to the uploaded sources)")
+	}
+	mapping.FS = sources
+	return mapping, nil
+}